@@ -1,5 +1,5 @@
 // gobrowse - advanced Go TUI file browser
-// Single-file implementation (main.go)
+// Implementation lives in main.go; sys_*.go hold the platform-specific bits.
 // Features:
 // - Dual-pane TUI using tview (file list + preview)
 // - Navigation (Enter, Backspace), bookmarks, search/filter
@@ -11,28 +11,50 @@
 // Usage:
 //   go mod init gobrowse
 //   go get github.com/rivo/tview github.com/gdamore/tcell/v2
-//   go run main.go
+//   go run .
 
 package main
 
 import (
 	"bufio"
 	"bytes"
+	"cmp"
+	"compress/bzip2"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
 	"github.com/rivo/tview"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 // -----------------------------
@@ -41,17 +63,208 @@ import (
 var (
 	PreviewMaxBytes  = 200 * 1024 // 200 KB
 	TextPreviewLines = 1000
+	DirsFirst        = true // group directories before files when sorting
+	// PreviewMaxBytesByExt overrides PreviewMaxBytes for text files with
+	// these extensions (lowercase, with the dot). config.json adds to it
+	// under "preview_max_bytes".
+	PreviewMaxBytesByExt = map[string]int{}
+	// OpenManyThreshold is how many marked files can be opened at once
+	// before asking for confirmation.
+	OpenManyThreshold = 10
+	// DestRelative pre-fills copy/move destinations relative to the current
+	// directory instead of as absolute paths.
+	DestRelative = true
+	// CopyDest picks what the copy prompt is pre-filled with: "suffix" for
+	// name.copy beside the original, "last" for the directory the previous
+	// copy this session went into, or the absolute path of a directory to
+	// copy into, such as "~/Archive". config.json can set it as
+	// "copy_destination".
+	CopyDest = "suffix"
+	// EnterOpensFile makes Enter on a file behave like the open key and hand
+	// it to the system default application; the preview still follows the
+	// cursor. When false Enter previews the file. Directories are always
+	// entered.
+	EnterOpensFile = false
+	// SearchMaxResults caps how many hits a recursive search collects.
+	SearchMaxResults = 1000
+	// MediaProbe shows duration/codec details for audio and video files
+	// when ffprobe is on the PATH.
+	MediaProbe        = true
+	MediaProbeTimeout = 5 * time.Second
+	ShowPreview       = true // start with the preview pane visible
+	// The list and preview split the width in PaneWidthTotal parts; the list
+	// gets ListWeight of them by default.
+	ListWeight     = 3
+	PaneWidthTotal = 8
+	// CopyBufferSize sets the buffer used per file copy; 0 lets the OS pick
+	// the fastest path (e.g. copy_file_range on Linux).
+	CopyBufferSize = 0
+	// CopyWorkers is how many files copyDir copies concurrently.
+	CopyWorkers = 4
+	// ShowExtColumn starts with the extension column shown.
+	ShowExtColumn = false
+	ShowHidden    = true // list dotfiles
+	// SortTieBreak orders entries that tie under the current sort mode:
+	// "name", "size", "time" or "ext". Exact names break any remaining tie.
+	SortTieBreak = "name"
+	// SortLocale, a language tag such as "en" or "de", sorts names with
+	// that locale's collation so accented letters sit next to plain ones.
+	// Empty compares lowercased bytes, which is faster. config.json can set
+	// it as "sort_locale".
+	SortLocale = ""
+	// ModTimeFormat picks how modification times are shown in the preview:
+	// "absolute", "relative" or "both".
+	ModTimeFormat = "both"
+	// PreviewHeader starts text previews with a line giving the file's
+	// name, size, line count and encoding.
+	PreviewHeader = true
+	// FollowSymlinks enters symlinks to directories like the directories
+	// themselves and copies what links point at. Off, such links are not
+	// entered and copies recreate links as links. config.json can set it
+	// as "follow_symlinks".
+	FollowSymlinks = true
+	// EscQuits makes Esc in the file list quit once there are no filters
+	// left to clear. Esc always closes prompts and overlays. config.json can
+	// set it as "esc_quits".
+	EscQuits = false
+	// EntryStyle sets how directories and symlinks stand out in the list:
+	// "prefix" puts [DIR] or [LNK] in front, "suffix" appends / or @ as
+	// ls -F does, and "icons" puts a Nerd Font glyph in front of every
+	// entry, picked for files by extension from FileIcons. config.json can
+	// set it as "entry_style".
+	EntryStyle = "prefix"
+	// FileIcons are the Nerd Font glyphs shown in front of files by
+	// extension under the "icons" entry style; other files get a plain page.
+	// config.json adds to or overrides them under "icons".
+	FileIcons = map[string]string{
+		// code
+		".go": "\ue627", ".py": "\ue606", ".js": "\ue74e", ".ts": "\ue628",
+		".rs": "\ue7a8", ".c": "\ue61e", ".cpp": "\ue61d", ".java": "\ue738",
+		".rb": "\ue739", ".php": "\ue73d", ".lua": "\ue620", ".swift": "\ue755",
+		".sh": "\uf489", ".html": "\ue736", ".css": "\ue749",
+		// data and config
+		".json": "\ue60b", ".yaml": "\ue615", ".yml": "\ue615", ".toml": "\ue615",
+		".ini": "\ue615", ".conf": "\ue615",
+		// documents
+		".md": "\uf48a", ".txt": "\uf15c", ".pdf": "\uf1c1",
+		".doc": "\uf1c2", ".docx": "\uf1c2", ".xls": "\uf1c3", ".xlsx": "\uf1c3",
+		".csv": "\uf1c3", ".ppt": "\uf1c4", ".pptx": "\uf1c4",
+		// images
+		".png": "\uf1c5", ".jpg": "\uf1c5", ".jpeg": "\uf1c5", ".gif": "\uf1c5",
+		".bmp": "\uf1c5", ".webp": "\uf1c5", ".svg": "\uf1c5", ".ico": "\uf1c5",
+		// audio and video
+		".mp3": "\uf1c7", ".wav": "\uf1c7", ".flac": "\uf1c7", ".ogg": "\uf1c7",
+		".mp4": "\uf1c8", ".mkv": "\uf1c8", ".mov": "\uf1c8", ".avi": "\uf1c8", ".webm": "\uf1c8",
+		// archives
+		".zip": "\uf410", ".tar": "\uf410", ".gz": "\uf410", ".tgz": "\uf410",
+		".bz2": "\uf410", ".xz": "\uf410", ".zst": "\uf410", ".7z": "\uf410", ".rar": "\uf410",
+	}
+	// TimeLayout is the Go time layout used wherever a date or time is
+	// shown. config.json can set it as "time_format".
+	TimeLayout = time.RFC1123
+	// RespectGitIgnore makes searches and the fuzzy finder skip what
+	// .gitignore excludes when run inside a git work tree.
+	RespectGitIgnore = true
+	// Editor runs when no $VISUAL or $EDITOR is set.
+	Editor = "vi"
+	// EditorLineStyles say how each editor (by program name) is told which
+	// line to open at: "+line" passes +N before the file, "file:line"
+	// appends :N to it and "--goto" passes --goto file:N. Editors not
+	// listed open at the top.
+	EditorLineStyles = map[string]string{
+		"vi": "+line", "vim": "+line", "nvim": "+line", "nano": "+line",
+		"emacs": "+line", "emacsclient": "+line", "kak": "+line",
+		"micro": "file:line", "hx": "file:line", "helix": "file:line", "subl": "file:line",
+		"code": "--goto", "codium": "--goto",
+	}
+	// ConfirmDelete sets when deleting asks first: "always", "never",
+	// "dirs" (a directory is involved), "many" (more than ConfirmDeleteMax
+	// entries) or "dirs-or-many".
+	ConfirmDelete    = "dirs-or-many"
+	ConfirmDeleteMax = 1
+	// UseTrash makes delete move entries to the trash instead of removing
+	// them. At startup entries older than TrashMaxAge are purged, then the
+	// oldest ones until the trash fits in TrashMaxSize bytes; 0 turns either
+	// limit off.
+	UseTrash     = true
+	TrashMaxAge  = 30 * 24 * time.Hour
+	TrashMaxSize = int64(2 << 30)
+	// PreviewScrollMemory is how many files' preview scroll positions are
+	// remembered for when they are selected again.
+	PreviewScrollMemory = 200
+	// ActivityLogMax bounds how many file operations the activity log keeps.
+	ActivityLogMax = 500
+	// ActivityLogFile, when set, also gets every activity log entry
+	// appended to it.
+	ActivityLogFile = ""
+	// DirHook is a shell command run on entering a directory, with {}
+	// replaced by the directory's quoted path. Its output is shown in the
+	// preview until the cursor moves. Empty turns it off; config.json can
+	// set it as "dir_hook".
+	DirHook        = ""
+	DirHookTimeout = 3 * time.Second
+	// TextExtensions are always previewed and searched as text. More can be
+	// added in config.json under "text_extensions".
+	TextExtensions = []string{".txt", ".md", ".go", ".py", ".java", ".c", ".cpp", ".json", ".csv", ".tsv", ".yaml", ".yml", ".xml", ".html", ".css", ".js", ".sh"}
 
-	KeyOpen     = 'o' // open with system default
-	KeyDelete   = 'd'
-	KeyRename   = 'r'
-	KeyCopy     = 'c'
-	KeyMove     = 'm'
-	KeyBookmark = 'b'
-	KeyListBook = 'B'
-	KeySearch   = '/'
-	KeyHelp     = 'h'
-	KeyQuit     = 'q'
+	KeyOpen       = 'o' // open with system default
+	KeyDelete     = 'd'
+	KeyRename     = 'r'
+	KeyCopy       = 'c'
+	KeyMove       = 'm'
+	KeyBookmark   = 'b'
+	KeyListBook   = 'B'
+	KeySearch     = '/'
+	KeyHelp       = 'h'
+	KeyQuit       = 'q'
+	KeyDirsFirst  = 'D'
+	KeyMark       = ' ' // mark/unmark for batch operations
+	KeySymlink    = 'S'
+	KeyHardlink   = 'H'
+	KeyFind       = 'f' // recursive filename search
+	KeyGrep       = 'F' // recursive content search
+	KeyPreview    = 'p' // show/hide the preview pane
+	KeyShrink     = '<' // narrow the file list
+	KeyGrow       = '>' // widen the file list
+	KeySort       = 's' // cycle sort mode: name, size, time, ext
+	KeyReverse    = 'R' // reverse sort order
+	KeyResults    = 'n' // back to the last search results
+	KeyFuzzy      = 'z' // fuzzy find files below here
+	KeyExtColumn  = 'e' // show/hide the extension column
+	KeyShowType   = 't' // cycle showing all entries, only dirs, only files
+	KeyHidden     = '.' // show/hide dotfiles
+	KeyBulkName   = 'E' // replace text in the names of marked entries
+	KeyUndo       = 'u'
+	KeyCopyPath   = 'y' // copy the selected entry's path to the clipboard
+	KeyCopyDir    = 'Y' // copy the current directory's path
+	KeyPalette    = ':' // command palette, also on Ctrl-P
+	KeyIgnored    = 'I' // include/skip .gitignored files in searches
+	KeyActivity   = 'L' // show the activity log
+	KeyInvert     = '!' // invert the name filter
+	KeyEdit       = 'v' // open in $EDITOR at the previewed line
+	KeyBookFile   = 'K' // bookmark the selected file
+	KeyReveal     = 'O' // show the selection in the system file manager
+	KeyEmptyTrash = 'X'
+	KeyDiff       = 'x' // diff against a backup or a chosen file
+	KeyMarked     = 'M' // list or clear the marks from every directory
+	KeyDuplicate  = 'C' // copy the selection beside itself
+	KeyPin        = 'P' // keep the selection at the top of its directory
+	KeyRaw        = 'V' // preview JSON, CSV and markdown as source or rendered
+	KeyParentTab  = 'T' // open the parent directory in a new tab
+	KeyNextTab    = ']'
+	KeyPrevTab    = '['
+	KeyCloseTab   = 'W'
+	KeyExport     = 'A' // write the visible listing to a file
+	KeyTag        = '#' // color-tag the marked entries or the selection
+	KeyTagFilter  = '%' // only list entries with a chosen tag
+	KeyMaximize   = 'Z' // preview full screen, and back
+	KeyFileTo     = 'J' // copy or move the selection to a bookmarked directory
+	KeyStats      = 'i' // show/hide the stats line above text previews
+	KeyLinks      = 'l' // follow symlinks, or leave them be
+	KeyRestore    = 'U' // put an entry from the trash back
+	KeyDryRun     = 'N' // only show what file operations would do
+	KeyOps        = 'Q' // queued, running and finished copies, moves and deletes
+	KeyBookSlot   = '`' // then 1-9: jump to that bookmark
 )
 
 // -----------------------------
@@ -63,11 +276,205 @@ type AppState struct {
 	filesList  *tview.List
 	preview    *tview.TextView
 	status     *tview.TextView
+	hints      *tview.TextView // keys for whatever has focus, under the status
 	currentDir string
 	files      []fs.DirEntry
 	lock       sync.Mutex
-	bookmarks  []string
-	searchTerm string
+	bookmarks  []bookmark
+	filter     nameFilter
+	showType   showType
+	showHidden bool
+	dirsFirst  bool
+	statusMsg  string
+	selected   map[string]bool // marked entries by full path, kept across directories
+	pins       map[string]bool // pinned entries by full path, from prefs.Pins
+	tabs       []tab           // the current tab's entry is stale until saveTab
+	tabIndex   int
+	tags       map[string]string // color tag by full path, kept in tags.json
+	tagFilter  string            // only list entries with this tag
+
+	searchCancel context.CancelFunc // stops the running recursive search
+	lastSearch   *searchView
+	lastMatch    searchResult // content hit last jumped to from the results
+	lastOp       *undoOp
+	activity     []activityEntry // file operations this session, oldest first
+	fuzzyLock    sync.Mutex
+	fuzzyCache   map[string]*fuzzyIndex // root -> index
+	withIgnored  bool                   // searches include .gitignored files
+	sortMode     sortMode
+	sortReverse  bool
+	showPreview  bool
+	followLinks  bool // enter linked directories and copy link targets
+	dryRun       bool // plan deletes, moves, copies and bulk renames without doing them
+	readOnly     bool // every action that changes files is turned off
+	ops          *opQueue
+	prefs        uiPrefs
+
+	keyActions map[rune]action // filled from actions() by setupKeys
+	pendingG   bool            // 'g' was pressed; another one jumps to the top
+	pendingJmp bool            // KeyBookSlot was pressed; a digit picks the bookmark
+	screen     tcell.Screen    // last screen drawn to; used for OSC 52 clipboard writes
+
+	previewGen    atomic.Uint64 // bumped per preview load; older results are dropped
+	previewShown  previewFile   // text file in the preview, for scrollMemory
+	selInfoGen    atomic.Uint64 // bumped per selection; older details are dropped
+	selInfo       string        // details of the entry under the cursor, for the status bar
+	previewOffset int           // rows above the file's first line, e.g. the stats line
+	rawPreview    atomic.Bool   // show structured files as source, not rendered
+	previewHeader atomic.Bool   // start text previews with a stats line
+	previewMax    bool          // the preview fills the window
+	previewFind   string        // last text searched for in the maximized preview
+	scrolls       scrollMemory
+	previewPath   string             // entry the preview was last loaded for
+	previewCtx    context.Context    // cancelled when the selection moves on
+	previewCancel context.CancelFunc // stops background work for previewPath
+
+	refreshGen    atomic.Uint64 // bumped per refresh; stale results are dropped
+	pendingSelect string        // name to put the cursor on after the next rebuild
+	lastCopyDir   string        // directory the last copy went into, for CopyDest "last"
+
+	pendingStatus atomic.Pointer[string] // message for the next status redraw; see updateStatus
+
+	itemNames   []string    // file name of each list item, by index
+	hiddenCount int         // dotfiles left out of the list by the hidden toggle
+	nameWidth   int         // widest label in the list, for column alignment
+	nameBudget  int         // cells a name may take before it is shortened; 0 = no limit
+	listWidth   int         // list width the labels were last laid out for
+	columns     listColumns // optional columns shown after the name
+
+	infoLock  sync.Mutex
+	infoCache map[string]map[string]fs.FileInfo // dir -> name -> stat
+}
+
+// showType limits the list to one kind of entry.
+type showType int
+
+const (
+	showAll showType = iota
+	showDirs
+	showFiles
+)
+
+func (t showType) String() string {
+	return [...]string{"all", "dirs", "files"}[t]
+}
+
+func (t showType) allows(e fs.DirEntry) bool {
+	switch t {
+	case showDirs:
+		return e.IsDir()
+	case showFiles:
+		return !e.IsDir()
+	}
+	return true
+}
+
+// listColumns selects the optional columns shown after each name; each can
+// be toggled on its own.
+type listColumns struct {
+	ext bool
+}
+
+// uiPrefs are the global view settings remembered between runs.
+type uiPrefs struct {
+	ListWeight  int    `json:"list_weight"`
+	ShowPreview bool   `json:"show_preview"`
+	SortMode    string `json:"sort_mode"`
+	SortReverse bool   `json:"sort_reverse"`
+	DirsFirst   bool   `json:"dirs_first"`
+	ShowHidden  bool   `json:"show_hidden"`
+	// Pins are the full paths of entries listed above everything else in
+	// their directory.
+	Pins []string `json:"pins,omitempty"`
+}
+
+func defaultPrefs() uiPrefs {
+	return uiPrefs{
+		ListWeight:  ListWeight,
+		ShowPreview: ShowPreview,
+		SortMode:    sortByName.String(),
+		DirsFirst:   DirsFirst,
+		ShowHidden:  ShowHidden,
+	}
+}
+
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gobrowse"), nil
+}
+
+func prefsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "prefs.json"), nil
+}
+
+// loadPrefs reads the saved preferences, falling back to the defaults for
+// anything missing or out of range.
+func loadPrefs() uiPrefs {
+	prefs := defaultPrefs()
+	if path, err := prefsPath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &prefs)
+		}
+	}
+	prefs.ListWeight = clampListWeight(prefs.ListWeight)
+	return prefs
+}
+
+func (p uiPrefs) save() error {
+	path, err := prefsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// resetPrefs throws away the saved preferences.
+func resetPrefs() error {
+	path, err := prefsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// savePrefs records the current view settings as the new baseline.
+func (s *AppState) savePrefs() {
+	s.prefs.ShowPreview = s.showPreview
+	s.prefs.SortMode = s.sortMode.String()
+	s.prefs.SortReverse = s.sortReverse
+	s.prefs.DirsFirst = s.dirsFirst
+	s.prefs.ShowHidden = s.showHidden
+	if err := s.prefs.save(); err != nil {
+		s.updateStatus("Could not save preferences: " + err.Error())
+	}
+}
+
+// clampListWeight keeps both panes at least one part wide.
+func clampListWeight(w int) int {
+	if w < 1 {
+		return 1
+	}
+	if w > PaneWidthTotal-1 {
+		return PaneWidthTotal - 1
+	}
+	return w
 }
 
 // -----------------------------
@@ -90,11 +497,206 @@ func humanSize(n int64) string {
 	return fmt.Sprintf("%.1f GB", gb)
 }
 
+// relativeTime renders d (the age of something) in words, e.g. "just now",
+// "5 minutes ago" or "2 years ago". Negative durations read as "in ...".
+func relativeTime(d time.Duration) string {
+	suffix := " ago"
+	prefix := ""
+	if d < 0 {
+		d = -d
+		prefix, suffix = "in ", ""
+	}
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%s1 %s%s", prefix, unit, suffix)
+		}
+		return fmt.Sprintf("%s%d %ss%s", prefix, n, unit, suffix)
+	}
+	const day = 24 * time.Hour
+	switch {
+	case d < 10*time.Second:
+		return "just now"
+	case d < time.Minute:
+		return plural(int64(d/time.Second), "second")
+	case d < time.Hour:
+		return plural(int64(d/time.Minute), "minute")
+	case d < day:
+		return plural(int64(d/time.Hour), "hour")
+	case d < 30*day:
+		return plural(int64(d/day), "day")
+	case d < 365*day:
+		return plural(int64(d/(30*day)), "month")
+	default:
+		return plural(int64(d/(365*day)), "year")
+	}
+}
+
+// formatModTime renders t according to ModTimeFormat.
+func formatModTime(t time.Time) string {
+	abs := t.Format(TimeLayout)
+	rel := relativeTime(time.Since(t))
+	switch ModTimeFormat {
+	case "absolute":
+		return abs
+	case "relative":
+		return rel
+	default:
+		return rel + " (" + abs + ")"
+	}
+}
+
+// textExts is the merged set of text extensions; it is filled in once at
+// startup by loadTextExts and only read afterwards.
+var textExts = make(map[string]bool)
+
+// userConfig holds the settings a user edits by hand in config.json, next
+// to prefs.json. Unlike the preferences it is never written back.
+type userConfig struct {
+	TextExtensions []string `json:"text_extensions"`
+	DirHook        string   `json:"dir_hook"`
+	SortLocale     string   `json:"sort_locale"`
+	TimeFormat     string   `json:"time_format"`
+	EntryStyle     string   `json:"entry_style"`
+	CopyDest       string   `json:"copy_destination"`
+	EscQuits       bool     `json:"esc_quits"`
+	// FollowSymlinks is a pointer so that leaving it out keeps the
+	// default.
+	FollowSymlinks *bool `json:"follow_symlinks"`
+	// Icons maps extensions to the glyphs shown for them.
+	Icons map[string]string `json:"icons"`
+	// PreviewMaxBytes maps extensions to their preview size limit.
+	PreviewMaxBytes map[string]int `json:"preview_max_bytes"`
+}
+
+// validTimeLayout reports whether layout has at least one date or time
+// element; anything else would print the same literal text for every time.
+func validTimeLayout(layout string) bool {
+	return layout != "" && time.Unix(0, 0).UTC().Format(layout) != layout
+}
+
+func loadUserConfig() (userConfig, error) {
+	var cfg userConfig
+	dir, err := configDir()
+	if err != nil {
+		return cfg, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return cfg, nil
+		}
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("config.json: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadTextExts merges the built-in TextExtensions with the user's own.
+// Extensions may be given with or without the leading dot.
+func loadTextExts(cfg userConfig) {
+	for _, list := range [][]string{TextExtensions, cfg.TextExtensions} {
+		for _, ext := range list {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext == "" {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			textExts[ext] = true
+		}
+	}
+}
+
 func isTextFile(name string) bool {
+	return textExts[strings.ToLower(filepath.Ext(name))]
+}
+
+func isMediaFile(name string) bool {
 	ext := strings.ToLower(filepath.Ext(name))
-	textExt := map[string]bool{
-		".txt": true, ".md": true, ".go": true, ".py": true, ".java": true, ".c": true, ".cpp": true, ".json": true, ".yaml": true, ".yml": true, ".xml": true, ".html": true, ".css": true, ".js": true, ".sh": true}
-	return textExt[ext]
+	mediaExt := map[string]bool{
+		".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true, ".m4v": true,
+		".mp3": true, ".flac": true, ".wav": true, ".ogg": true, ".m4a": true, ".aac": true, ".opus": true}
+	return mediaExt[ext]
+}
+
+var (
+	ffprobeOnce sync.Once
+	ffprobePath string
+
+	mediaCacheLock sync.Mutex
+	mediaCache     = make(map[string]string) // keyed by path, size and modtime
+)
+
+// probeMedia describes an audio/video file using ffprobe. Results are
+// cached per file version so reselecting a file doesn't probe it again.
+func probeMedia(path string, info fs.FileInfo) (string, error) {
+	ffprobeOnce.Do(func() { ffprobePath, _ = exec.LookPath("ffprobe") })
+	if ffprobePath == "" {
+		return "", errors.New("ffprobe not found")
+	}
+	key := fmt.Sprintf("%s|%d|%d", path, info.Size(), info.ModTime().UnixNano())
+	mediaCacheLock.Lock()
+	cached, ok := mediaCache[key]
+	mediaCacheLock.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), MediaProbeTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, ffprobePath, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", path).Output()
+	if err != nil {
+		return "", err
+	}
+	desc, err := describeProbe(out)
+	if err != nil {
+		return "", err
+	}
+
+	mediaCacheLock.Lock()
+	mediaCache[key] = desc
+	mediaCacheLock.Unlock()
+	return desc, nil
+}
+
+// describeProbe turns ffprobe's JSON output into the lines shown in the
+// preview. The duration is rounded to the nearest second.
+func describeProbe(out []byte) (string, error) {
+	var probe struct {
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if secs, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		fmt.Fprintf(&b, "Duration: %s\n", time.Duration(secs*float64(time.Second)).Round(time.Second))
+	}
+	if bps, err := strconv.ParseInt(probe.Format.BitRate, 10, 64); err == nil {
+		fmt.Fprintf(&b, "Bitrate: %d kb/s\n", bps/1000)
+	}
+	for _, st := range probe.Streams {
+		switch st.CodecType {
+		case "video":
+			fmt.Fprintf(&b, "Video: %s %dx%d\n", st.CodecName, st.Width, st.Height)
+		case "audio":
+			fmt.Fprintf(&b, "Audio: %s\n", st.CodecName)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n"), nil
 }
 
 func systemOpen(path string) error {
@@ -110,6 +712,68 @@ func systemOpen(path string) error {
 	return cmd.Start()
 }
 
+// systemReveal shows path in the platform file manager, highlighted where
+// the file manager supports it. On Linux there is no common way to select
+// a file, so the parent directory is opened instead.
+func systemReveal(path string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", "-R", path)
+	case "windows":
+		cmd = exec.Command("explorer", "/select,"+path)
+	default:
+		cmd = exec.Command("xdg-open", filepath.Dir(path))
+	}
+	return cmd.Start()
+}
+
+// clipboardCommand returns the program used to set the system clipboard,
+// or nil if none is available.
+func clipboardCommand() *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbcopy")
+	case "windows":
+		return exec.Command("clip")
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return exec.Command("wl-copy")
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return exec.Command("xclip", "-selection", "clipboard")
+	}
+	if _, err := exec.LookPath("xsel"); err == nil {
+		return exec.Command("xsel", "--clipboard", "--input")
+	}
+	return nil
+}
+
+// copyToClipboard puts text on the system clipboard. Without a clipboard
+// program (e.g. over SSH) it asks the terminal to do it via OSC 52, which
+// most modern terminals support. Must run on the UI goroutine.
+func (s *AppState) copyToClipboard(text string) error {
+	if cmd := clipboardCommand(); cmd != nil {
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	if s.screen == nil {
+		return errors.New("no clipboard available")
+	}
+	s.screen.SetClipboard([]byte(text))
+	return nil
+}
+
+func (s *AppState) copyPathToClipboard(path string) {
+	if err := s.copyToClipboard(path); err != nil {
+		s.updateStatus("Copy to clipboard failed: " + err.Error())
+		return
+	}
+	s.updateStatus("Copied " + path)
+}
+
 // -----------------------------
 // App Methods
 // -----------------------------
@@ -119,431 +783,5919 @@ func NewAppState() (*AppState, error) {
 	if err != nil {
 		return nil, err
 	}
+	prefs := loadPrefs()
 	state := &AppState{
-		app:        tview.NewApplication(),
-		filesList:  tview.NewList().ShowSecondaryText(false),
-		preview:    tview.NewTextView().SetDynamicColors(true).SetWrap(true).SetChangedFunc(func() { state.app.Draw() }),
-		status:     tview.NewTextView().SetDynamicColors(true),
-		currentDir: cwd,
-		bookmarks:  make([]string, 0),
+		app:         tview.NewApplication(),
+		filesList:   tview.NewList().ShowSecondaryText(false),
+		status:      tview.NewTextView().SetDynamicColors(true),
+		hints:       tview.NewTextView().SetDynamicColors(true).SetWrap(false),
+		currentDir:  cwd,
+		bookmarks:   make([]bookmark, 0),
+		dirsFirst:   prefs.DirsFirst,
+		showHidden:  prefs.ShowHidden,
+		selected:    make(map[string]bool),
+		pins:        make(map[string]bool),
+		tabs:        []tab{{dir: cwd}},
+		tags:        loadTags(),
+		sortMode:    parseSortMode(prefs.SortMode),
+		sortReverse: prefs.SortReverse,
+		showPreview: prefs.ShowPreview,
+		prefs:       prefs,
+		infoCache:   make(map[string]map[string]fs.FileInfo),
+		fuzzyCache:  make(map[string]*fuzzyIndex),
+		columns:     listColumns{ext: ShowExtColumn},
+		followLinks: FollowSymlinks,
+		ops:         newOpQueue(),
 	}
+	for _, p := range prefs.Pins {
+		state.pins[p] = true
+	}
+	state.previewHeader.Store(PreviewHeader)
+	go state.runOps()
+	state.preview = tview.NewTextView().SetDynamicColors(true).SetWrap(true).SetChangedFunc(func() { state.app.Draw() })
 	return state, nil
 }
 
+// loadFiles reads and sorts currentDir synchronously. The UI uses
+// refreshList instead, which does the same off the UI goroutine.
 func (s *AppState) loadFiles() error {
-	s.lock.Lock()
-	defer s.lock.Unlock()
-
 	entries, err := os.ReadDir(s.currentDir)
 	if err != nil {
 		return err
 	}
+	s.invalidateInfos(s.currentDir)
+	entries = s.sortEntries(s.currentDir, entries, s.sortOptions())
 
+	s.lock.Lock()
 	s.files = entries
-	s.sortFiles()
+	s.lock.Unlock()
 	return nil
 }
 
-func (s *AppState) sortFiles() {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+type sortMode int
 
-	slice := make([]fs.DirEntry, 0, len(s.files))
-	for _, e := range s.files {
-		slice = append(slice, e)
+const (
+	sortByName sortMode = iota
+	sortBySize
+	sortByTime
+	sortByExt
+	sortModeCount
+)
+
+var sortModeNames = [...]string{"name", "size", "time", "ext"}
+
+func (m sortMode) String() string { return sortModeNames[m] }
+
+// parseSortMode maps a sort mode name back to its value, defaulting to
+// sorting by name.
+func parseSortMode(name string) sortMode {
+	m, _ := lookupSortMode(name)
+	return m
+}
+
+// lookupSortMode is parseSortMode that also reports whether name is valid.
+func lookupSortMode(name string) (sortMode, bool) {
+	for i, n := range sortModeNames {
+		if n == name {
+			return sortMode(i), true
+		}
+	}
+	return sortByName, false
+}
+
+// needsStat reports whether sorting by m needs each entry's FileInfo.
+func (m sortMode) needsStat() bool {
+	return m == sortBySize || m == sortByTime
+}
+
+type sortOptions struct {
+	mode      sortMode
+	tieBreak  sortMode // orders entries the primary mode sees as equal
+	reverse   bool
+	dirsFirst bool
+	pinned    map[string]bool // full paths sorted above everything else
+}
+
+func (s *AppState) sortOptions() sortOptions {
+	return sortOptions{mode: s.sortMode, tieBreak: parseSortMode(SortTieBreak), reverse: s.sortReverse, dirsFirst: s.dirsFirst, pinned: maps.Clone(s.pins)}
+}
+
+// sortEntries returns entries ordered per opts. Size and time sorts need a
+// stat per entry; those are gathered concurrently and cached, so it should
+// not be called on the UI goroutine.
+func (s *AppState) sortEntries(dir string, entries []fs.DirEntry, opts sortOptions) []fs.DirEntry {
+	slice := make([]fs.DirEntry, len(entries))
+	copy(slice, entries)
+	var infos map[string]fs.FileInfo
+	if opts.mode.needsStat() || opts.tieBreak.needsStat() {
+		infos = s.fileInfos(dir, slice)
+	}
+	compareNames := func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	}
+	if SortLocale != "" {
+		// a Collator is not safe for concurrent use, so each sort gets one
+		col := collate.New(language.Make(SortLocale), collate.IgnoreCase)
+		compareNames = col.CompareString
+	}
+	compare := func(mode sortMode, a, b fs.DirEntry) int {
+		switch mode {
+		case sortBySize:
+			return cmp.Compare(infoSize(infos[a.Name()]), infoSize(infos[b.Name()]))
+		case sortByTime:
+			return infoTime(infos[a.Name()]).Compare(infoTime(infos[b.Name()]))
+		case sortByExt:
+			return strings.Compare(strings.ToLower(filepath.Ext(a.Name())), strings.ToLower(filepath.Ext(b.Name())))
+		default:
+			return compareNames(a.Name(), b.Name())
+		}
 	}
 	sort.Slice(slice, func(i, j int) bool {
 		a, b := slice[i], slice[j]
-		// directories first
-		if a.IsDir() && !b.IsDir() {
-			return true
+		if pa, pb := opts.pinned[filepath.Join(dir, a.Name())], opts.pinned[filepath.Join(dir, b.Name())]; pa != pb {
+			return pa
 		}
-		if !a.IsDir() && b.IsDir() {
-			return false
+		// directories first, unless the user wants them mixed in
+		if opts.dirsFirst && a.IsDir() != b.IsDir() {
+			return a.IsDir()
 		}
-		return strings.ToLower(a.Name()) < strings.ToLower(b.Name())
-	})
-	s.files = slice
-}
-
-func (s *AppState) refreshList() {
-	_ = s.loadFiles()
-
-	s.app.QueueUpdateDraw(func() {
-		s.filesList.Clear()
-		// optionally filter by searchTerm
-		for _, e := range s.files {
-			name := e.Name()
-			if s.searchTerm != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(s.searchTerm)) {
-				continue
-			}
-			label := name
-			if e.IsDir() {
-				label = "[::b][DIR] " + name
-			}
-			// capture for closure
-			entry := e
-			s.filesList.AddItem(label, "", 0, func() {
-				s.onEnter(entry)
-			})
+		c := compare(opts.mode, a, b)
+		if opts.reverse {
+			c = -c
 		}
-		// add go back entry
-		if parent := filepath.Dir(s.currentDir); parent != s.currentDir {
-			s.filesList.AddItem("[..] Go up", "", 0, func() {
-				s.changeDir(filepath.Dir(s.currentDir))
-			})
+		// ties keep a fixed order, independent of the reverse toggle, so
+		// refreshes never reshuffle them; the exact name settles the rest
+		if c == 0 {
+			c = compare(opts.tieBreak, a, b)
 		}
-		// set default selection to first
-		if s.filesList.GetItemCount() > 0 {
-			s.filesList.SetCurrentItem(0)
+		if c == 0 {
+			c = strings.Compare(a.Name(), b.Name())
 		}
-		// update status
-		s.updateStatus("Ready")
+		return c < 0
 	})
+	return slice
 }
 
-func (s *AppState) changeDir(dir string) {
-	abs, _ := filepath.Abs(dir)
-	info, err := os.Stat(abs)
-	if err != nil || !info.IsDir() {
-		s.showModal("Not a directory: "+dir, []string{"OK"}, func(_ int, _ string) {})
-		return
+func infoSize(info fs.FileInfo) int64 {
+	if info == nil {
+		return 0
 	}
-	s.currentDir = abs
-	s.searchTerm = ""
-	s.refreshList()
-	s.loadPreviewForSelection()
+	return info.Size()
 }
 
-func (s *AppState) onEnter(entry fs.DirEntry) {
-	if entry.IsDir() {
-		s.changeDir(filepath.Join(s.currentDir, entry.Name()))
-		return
+func infoTime(info fs.FileInfo) time.Time {
+	if info == nil {
+		return time.Time{}
 	}
-	// file: preview or open
-	s.openPreview(filepath.Join(s.currentDir, entry.Name()))
+	return info.ModTime()
 }
 
-func (s *AppState) openPreview(path string) {
-	// open in system default if small binary? we provide both options. Default: preview if text
-	if isTextFile(path) {
-		s.loadTextPreview(path)
-	} else {
-		s.preview.Clear()
-		s.preview.SetText("(No text preview available. Press 'o' to open with system default.)")
+// fileInfos returns the FileInfo of each entry keyed by name, statting the
+// ones not cached yet with a small pool of goroutines.
+func (s *AppState) fileInfos(dir string, entries []fs.DirEntry) map[string]fs.FileInfo {
+	infos := make(map[string]fs.FileInfo, len(entries))
+	var missing []fs.DirEntry
+	s.infoLock.Lock()
+	cached := s.infoCache[dir]
+	for _, e := range entries {
+		if info, ok := cached[e.Name()]; ok {
+			infos[e.Name()] = info
+		} else {
+			missing = append(missing, e)
+		}
 	}
-}
+	s.infoLock.Unlock()
 
-func (s *AppState) loadPreviewForSelection() {
-	index := s.filesList.GetCurrentItem()
-	if index < 0 || index >= s.filesList.GetItemCount() {
-		s.preview.Clear()
-		return
+	const workers = 8
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	queue := make(chan fs.DirEntry)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range queue {
+				info, err := e.Info()
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				infos[e.Name()] = info
+				mu.Unlock()
+			}
+		}()
 	}
-	label, _ := s.filesList.GetItemText(index)
-	// strip dir tag if present
-	name := strings.TrimPrefix(label, "[::b][DIR] ")
-	if name == "[..] Go up" {
-		s.preview.SetText("")
-		return
+	for _, e := range missing {
+		queue <- e
 	}
-	path := filepath.Join(s.currentDir, name)
-	// if dir do nothing
-	if info, err := os.Stat(path); err == nil && info.IsDir() {
-		s.preview.SetText("[DIR] " + name)
-		return
+	close(queue)
+	wg.Wait()
+
+	s.infoLock.Lock()
+	if s.infoCache[dir] == nil {
+		s.infoCache[dir] = make(map[string]fs.FileInfo)
 	}
-	if isTextFile(path) {
-		go s.loadTextPreview(path)
-	} else {
-		// show file metadata
-		if info, err := os.Stat(path); err == nil {
-			s.preview.SetText(fmt.Sprintf("%s\nSize: %s\nModified: %s", name, humanSize(info.Size()), info.ModTime().Format(time.RFC1123)))
-		} else {
-			s.preview.SetText("(Unable to stat file)")
-		}
+	for name, info := range infos {
+		s.infoCache[dir][name] = info
 	}
+	s.infoLock.Unlock()
+	return infos
 }
 
-func (s *AppState) loadTextPreview(path string) {
-	s.app.QueueUpdateDraw(func() { s.preview.SetText("Loading preview...") })
-
-	f, err := os.Open(path)
-	if err != nil {
-		s.app.QueueUpdateDraw(func() { s.preview.SetText("Error opening file: " + err.Error()) })
-		return
-	}
-	defer f.Close()
+// invalidateInfos forgets cached stats for dir, e.g. after re-reading it.
+func (s *AppState) invalidateInfos(dir string) {
+	s.infoLock.Lock()
+	delete(s.infoCache, dir)
+	s.infoLock.Unlock()
+}
 
-	var buf bytes.Buffer
-	reader := bufio.NewReader(f)
-	// Read up to PreviewMaxBytes
-	n := 0
-	for n < PreviewMaxBytes {
-		line, err := reader.ReadString('\n')
-		buf.WriteString(line)
-		n += len(line)
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			}
-			break
-		}
-		// stop if too many lines
-		if strings.Count(buf.String(), "\n") > TextPreviewLines {
-			break
-		}
-	}
+func (s *AppState) refreshList() {
+	s.refreshListThen(0, nil)
+}
 
-	text := buf.String()
-	if len(text) == PreviewMaxBytes {
-		text += "\n... (truncated)"
-	}
+// refreshListSelect rebuilds the list and puts the cursor on index, clamped
+// to the remaining entries so a vanished last item falls back to its
+// predecessor rather than the "Go up" row.
+func (s *AppState) refreshListSelect(index int) {
+	s.refreshListThen(index, nil)
+}
 
-	s.app.QueueUpdateDraw(func() {
-		s.preview.SetText(text)
-	})
+// refreshListThen re-reads and sorts currentDir in the background, then
+// rebuilds the list on the UI goroutine and runs after (if set) there.
+// Results from a refresh that has since been superseded are dropped.
+func (s *AppState) refreshListThen(index int, after func()) {
+	gen := s.refreshGen.Add(1)
+	dir := s.currentDir
+	opts := s.sortOptions()
+	go func() {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			s.recoverFromMissingDir(gen, dir)
+			return
+		}
+		s.invalidateInfos(dir)
+		entries = s.sortEntries(dir, entries, opts)
+		s.publishFiles(gen, dir, entries, index, after)
+	}()
 }
 
-func (s *AppState) updateStatus(msg string) {
+// recoverFromMissingDir moves to the nearest existing ancestor when dir was
+// removed from under us, instead of leaving an empty list nothing can be
+// done in.
+func (s *AppState) recoverFromMissingDir(gen uint64, dir string) {
+	s.invalidateInfos(dir)
+	parent := existingAncestor(dir)
 	s.app.QueueUpdateDraw(func() {
-		s.status.SetText(fmt.Sprintf("[yellow]Dir:[-] %s  [green]|[-] %s", s.currentDir, msg))
+		if s.refreshGen.Load() != gen || dir != s.currentDir {
+			return
+		}
+		s.changeDir(parent)
+		s.updateStatus(dir + " no longer exists; moved to " + parent)
 	})
 }
 
-func (s *AppState) showModal(message string, buttons []string, done func(int, string)) {
-	modal := tview.NewModal().SetText(message).AddButtons(buttons).SetDoneFunc(done)
-	// push modal
-	root := s.app.GetRoot()
-	flex := tview.NewFlex().SetDirection(tview.FlexRow)
-	flex.AddItem(root, 0, 1, false)
-	flex.AddItem(modal, 0, 1, true)
-	_ = s.app.SetRoot(flex, true)
-	// when modal closed restore layout handled by done
+// resort reorders the entries already loaded after a sort option changed,
+// keeping the cursor on the same entry.
+func (s *AppState) resort() {
+	gen := s.refreshGen.Add(1)
+	dir := s.currentDir
+	opts := s.sortOptions()
+	s.lock.Lock()
+	files := s.files
+	s.lock.Unlock()
+	if path := s.selectedPath(); path != "" {
+		s.pendingSelect = filepath.Base(path)
+	}
+	go func() {
+		s.publishFiles(gen, dir, s.sortEntries(dir, files, opts), 0, nil)
+	}()
 }
 
-// File operations
-
-func (s *AppState) askInput(title, label, initial string, done func(text string, ok bool)) {
-	form := tview.NewForm()
-	input := tview.NewInputField().SetLabel(label).SetText(initial)
-	form.AddFormItem(input)
-	form.AddButton("OK", func() {
-		text := input.GetText()
-		_ = s.app.SetRoot(s.layout(), true)
-		done(text, true)
-	})
-	form.AddButton("Cancel", func() {
-		_ = s.app.SetRoot(s.layout(), true)
-		done("", false)
+func (s *AppState) publishFiles(gen uint64, dir string, entries []fs.DirEntry, index int, after func()) {
+	s.app.QueueUpdateDraw(func() {
+		if s.refreshGen.Load() != gen || dir != s.currentDir {
+			return
+		}
+		s.lock.Lock()
+		s.files = entries
+		s.lock.Unlock()
+		s.rebuildList(index)
+		if after != nil {
+			after()
+		}
 	})
-	form.SetBorder(true).SetTitle(title)
-	_ = s.app.SetRoot(form, true)
 }
 
-func (s *AppState) confirm(message string, done func(bool)) {
-	modal := tview.NewModal().SetText(message).AddButtons([]string{"Yes", "No"}).SetDoneFunc(func(index int, label string) {
-		_ = s.app.SetRoot(s.layout(), true)
-		done(label == "Yes")
-	})
-	_ = s.app.SetRoot(modal, true)
+// rebuildList fills the list widget from s.files. It runs on the UI
+// goroutine.
+func (s *AppState) rebuildList(index int) {
+	s.filesList.Clear()
+	s.itemNames = s.itemNames[:0]
+	var visible []fs.DirEntry
+	visible, s.hiddenCount = s.visibleEntries()
+	s.nameBudget = s.listNameBudget(visible)
+	s.nameWidth = 0
+	for _, e := range visible {
+		w := tview.TaggedStringWidth(s.itemLabel(e))
+		if !s.isMarked(e.Name()) {
+			// leave room for a mark so marking doesn't shift the columns
+			w += tview.TaggedStringWidth(markPrefix)
+		}
+		if w > s.nameWidth {
+			s.nameWidth = w
+		}
+	}
+	for _, e := range visible {
+		// Enter is dispatched by the list's selected func
+		s.filesList.AddItem(s.formatItem(e, s.nameWidth), "", 0, nil)
+		s.itemNames = append(s.itemNames, e.Name())
+	}
+	entries := s.filesList.GetItemCount()
+	// add go back entry
+	if parent := filepath.Dir(s.currentDir); parent != s.currentDir {
+		s.filesList.AddItem("[..] Go up", "", 0, nil)
+		s.itemNames = append(s.itemNames, goUpName)
+	}
+	if index >= entries {
+		index = entries - 1
+	}
+	if index < 0 {
+		index = 0
+	}
+	if s.filesList.GetItemCount() > 0 {
+		s.filesList.SetCurrentItem(index)
+	}
+	if s.pendingSelect != "" {
+		s.selectName(s.pendingSelect)
+		s.pendingSelect = ""
+	}
+	// update status; keep whatever message the caller left
+	s.renderStatus()
 }
 
-func (s *AppState) deleteSelection() {
-	idx := s.filesList.GetCurrentItem()
-	if idx < 0 {
-		return
+// visibleEntries returns the loaded entries that pass the name filter, the
+// dirs/files filter and the hidden toggle, and how many were only left out
+// for being hidden.
+func (s *AppState) visibleEntries() (visible []fs.DirEntry, hidden int) {
+	for _, e := range s.files {
+		name := e.Name()
+		if s.filter.active() && !s.filter.matches(name) {
+			continue
+		}
+		if !s.showType.allows(e) {
+			continue
+		}
+		if s.tagFilter != "" && s.tags[filepath.Join(s.currentDir, name)] != s.tagFilter {
+			continue
+		}
+		if !s.showHidden && strings.HasPrefix(name, ".") {
+			hidden++
+			continue
+		}
+		visible = append(visible, e)
 	}
-	label, _ := s.filesList.GetItemText(idx)
-	name := strings.TrimPrefix(label, "[::b][DIR] ")
-	path := filepath.Join(s.currentDir, name)
-	// confirm
-	s.confirm("Delete '"+name+"'? This cannot be undone.", func(ok bool) {
+	return visible, hidden
+}
+
+// listEntry is one line of --list output.
+type listEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modtime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// printListing writes the visible entries of currentDir to w, in list
+// order, as "plain", "csv" or "json". Plain output has one tab-separated
+// line per entry: mode, size, modification time (RFC 3339) and name; CSV
+// has the same columns under a header row.
+func (s *AppState) printListing(w io.Writer, format string) error {
+	visible, _ := s.visibleEntries()
+	// only the entries printed are statted, concurrently and through the
+	// cache a size or time sort has already filled
+	infos := s.fileInfos(s.currentDir, visible)
+	entries := make([]listEntry, 0, len(visible))
+	for _, e := range visible {
+		info, ok := infos[e.Name()]
 		if !ok {
+			// removed since the directory was read
+			continue
+		}
+		entries = append(entries, listEntry{Name: e.Name(), Size: info.Size(), Mode: info.Mode().String(), ModTime: info.ModTime(), IsDir: e.IsDir()})
+	}
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"mode", "size", "modified", "name"})
+		for _, e := range entries {
+			_ = cw.Write([]string{e.Mode, strconv.FormatInt(e.Size, 10), e.ModTime.Format(time.RFC3339), e.Name})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		fmt.Fprintf(bw, "%s\t%d\t%s\t%s\n", e.Mode, e.Size, e.ModTime.Format(time.RFC3339), e.Name)
+	}
+	return bw.Flush()
+}
+
+// exportListing writes the visible entries to a file, as CSV or JSON when
+// its name ends in .csv or .json and as tab-separated text otherwise.
+func (s *AppState) exportListing() {
+	s.askDest("Export listing to (.txt, .csv or .json)", filepath.Join(s.currentDir, "listing.txt"), func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
 			return
 		}
-		err := os.RemoveAll(path)
-		if err != nil {
-			s.showModal("Delete failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		dst := s.resolveDest(text, "listing.txt")
+		format := "plain"
+		switch strings.ToLower(filepath.Ext(dst)) {
+		case ".csv":
+			format = "csv"
+		case ".json":
+			format = "json"
+		}
+		write := func() {
+			f, err := os.Create(dst)
+			if err == nil {
+				err = s.printListing(f, format)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil {
+				s.showModal("Export failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+			s.updateStatus("Listing written to " + dst)
+			if filepath.Dir(dst) == s.currentDir {
+				s.refreshList()
+			}
+		}
+		if _, err := os.Stat(dst); err == nil {
+			s.confirm(dst+" already exists. Overwrite it?", func(ok bool) {
+				if ok {
+					write()
+				}
+			})
 			return
 		}
-		s.updateStatus("Deleted: " + name)
-		s.refreshList()
+		write()
 	})
 }
 
-func (s *AppState) renameSelection() {
-	idx := s.filesList.GetCurrentItem()
-	if idx < 0 {
+// List labels carry these decorations in front of the file name.
+const (
+	markPrefix = "[yellow]+[-] "
+	pinPrefix  = "[magenta]^[-] "
+	dirPrefix  = "[::b][DIR[] "
+	linkPrefix = "[cyan][LNK[][-] "
+)
+
+// entryStyles are the values EntryStyle may take.
+var entryStyles = []string{"prefix", "suffix", "icons"}
+
+// Nerd Font glyphs for the "icons" entry style.
+const (
+	dirIcon  = "\uf07b"
+	linkIcon = "\uf0c1"
+	fileIcon = "\uf15b"
+)
+
+// iconCells is how wide the icon column is: the widest glyph and a space.
+// loadIcons sets it once FileIcons is final.
+var iconCells = 2
+
+// loadIcons merges the user's icons into FileIcons and sizes the icon
+// column so that names line up even if some glyphs are wider than others.
+// Extensions may be given with or without the leading dot.
+func loadIcons(cfg userConfig) {
+	for ext, icon := range cfg.Icons {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		FileIcons[ext] = icon
+	}
+	widest := runewidth.StringWidth(dirIcon)
+	for _, icon := range FileIcons {
+		widest = max(widest, runewidth.StringWidth(icon))
+	}
+	iconCells = widest + 1
+}
+
+// iconCell pads icon to the icon column's width and colors it.
+func iconCell(icon, color string) string {
+	pad := strings.Repeat(" ", max(1, iconCells-runewidth.StringWidth(icon)))
+	if color == "" {
+		return tview.Escape(icon) + pad
+	}
+	return "[" + color + "]" + tview.Escape(icon) + "[-]" + pad
+}
+
+// decorate adds the EntryStyle marks for e's type to its escaped label.
+func decorate(e fs.DirEntry, label string) string {
+	isLink := e.Type()&fs.ModeSymlink != 0
+	switch EntryStyle {
+	case "suffix":
+		if e.IsDir() {
+			return label + "/"
+		} else if isLink {
+			return label + "@"
+		}
+		return label
+	case "icons":
+		switch {
+		case e.IsDir():
+			return iconCell(dirIcon, "blue") + label
+		case isLink:
+			return iconCell(linkIcon, "cyan") + label
+		}
+		if icon, ok := FileIcons[strings.ToLower(filepath.Ext(e.Name()))]; ok {
+			return iconCell(icon, "") + label
+		}
+		return iconCell(fileIcon, "") + label
+	}
+	if e.IsDir() {
+		return dirPrefix + label
+	} else if isLink {
+		return linkPrefix + label
+	}
+	return label
+}
+
+// decorationWidth is the most cells decorate adds to a name.
+func decorationWidth() int {
+	switch EntryStyle {
+	case "suffix":
+		return 1
+	case "icons":
+		return iconCells
+	}
+	return tview.TaggedStringWidth(dirPrefix)
+}
+
+func (s *AppState) itemLabel(e fs.DirEntry) string {
+	// names may hold text tview would read as a color tag
+	label := tview.Escape(truncateMiddle(e.Name(), s.nameBudget))
+	if tag := s.tags[filepath.Join(s.currentDir, e.Name())]; tag != "" {
+		label = "[" + tag + "]" + label + "[-]"
+	}
+	label = decorate(e, label)
+	if s.pins[filepath.Join(s.currentDir, e.Name())] {
+		label = pinPrefix + label
+	}
+	if s.isMarked(e.Name()) {
+		label = markPrefix + label
+	}
+	return label
+}
+
+// listNameBudget works out how many cells a name may use given the list's
+// current width, the label decorations and any columns after it. Before the
+// list is first drawn its width is unknown and names are left alone.
+func (s *AppState) listNameBudget(visible []fs.DirEntry) int {
+	_, _, width, _ := s.filesList.GetInnerRect()
+	if width <= 0 {
+		return 0
+	}
+	reserve := tview.TaggedStringWidth(markPrefix) + tview.TaggedStringWidth(pinPrefix) + decorationWidth()
+	if s.columns.ext {
+		extWidth := 0
+		for _, e := range visible {
+			if w := runewidth.StringWidth(filepath.Ext(e.Name())); w > extWidth {
+				extWidth = w
+			}
+		}
+		reserve += 2 + extWidth
+	}
+	if budget := width - reserve; budget > 8 {
+		return budget
+	}
+	return 8
+}
+
+// truncateMiddle shortens name to at most max cells by cutting out the
+// middle of the stem, keeping the extension visible ("long…name.txt"). A
+// max of 0 or less leaves name alone.
+func truncateMiddle(name string, max int) string {
+	if max <= 0 || runewidth.StringWidth(name) <= max {
+		return name
+	}
+	ext := filepath.Ext(name)
+	if runewidth.StringWidth(ext) > max/2 {
+		ext = ""
+	}
+	stem := []rune(strings.TrimSuffix(name, ext))
+	avail := max - runewidth.StringWidth(ext) - 1 // room for the ellipsis
+	headWidth := (avail + 1) / 2
+	tailWidth := avail - headWidth
+
+	var head, tail []rune
+	w := 0
+	for _, r := range stem {
+		rw := runewidth.RuneWidth(r)
+		if w+rw > headWidth {
+			break
+		}
+		head = append(head, r)
+		w += rw
+	}
+	w = 0
+	for i := len(stem) - 1; i >= len(head); i-- {
+		rw := runewidth.RuneWidth(stem[i])
+		if w+rw > tailWidth {
+			break
+		}
+		tail = append([]rune{stem[i]}, tail...)
+		w += rw
+	}
+	return string(head) + "…" + string(tail) + ext
+}
+
+// goUpName stands in for the "Go up" row in itemNames; no real entry can
+// be called "..".
+const goUpName = ".."
+
+// formatItem renders the list row for e, padding the label to nameWidth
+// cells when extra columns follow it.
+func (s *AppState) formatItem(e fs.DirEntry, nameWidth int) string {
+	label := s.itemLabel(e)
+	if !s.columns.ext {
+		return label
+	}
+	pad := nameWidth - tview.TaggedStringWidth(label)
+	if pad < 0 {
+		pad = 0
+	}
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(e.Name())), ".")
+	if e.IsDir() {
+		ext = ""
+	}
+	return label + strings.Repeat(" ", pad+2) + "[gray]" + tview.Escape(ext) + "[-]"
+}
+
+func (s *AppState) changeDir(dir string) {
+	abs, _ := filepath.Abs(dir)
+	info, err := os.Stat(abs)
+	if err != nil || !info.IsDir() {
+		s.showModal("Not a directory: "+dir, []string{"OK"}, func(_ int, _ string) {})
 		return
 	}
-	label, _ := s.filesList.GetItemText(idx)
-	name := strings.TrimPrefix(label, "[::b][DIR] ")
-	old := filepath.Join(s.currentDir, name)
-	initial := name
-	s.askInput("Rename", "New name:", initial, func(text string, ok bool) {
-		if !ok || strings.TrimSpace(text) == "" {
+	if !s.followLinks {
+		if linfo, err := os.Lstat(abs); err == nil && linfo.Mode()&fs.ModeSymlink != 0 {
+			target, _ := os.Readlink(abs)
+			s.showModal(fmt.Sprintf("%s is a symlink to %s. Symlinks are not followed; press %s to follow them.", dir, target, keyName(KeyLinks)), []string{"OK"}, func(_ int, _ string) {})
 			return
 		}
-		newPath := filepath.Join(s.currentDir, text)
-		err := os.Rename(old, newPath)
+	}
+	s.currentDir = abs
+	s.filter = nameFilter{}
+	s.showType = showAll
+	s.tagFilter = ""
+	s.updateStatus("Ready")
+	if DirHook != "" {
+		s.refreshListThen(0, s.runDirHook)
+		return
+	}
+	s.refreshListThen(0, s.loadPreviewForSelection)
+}
+
+// runDirHook shows the output of DirHook for the current directory in the
+// preview. A failing or slow hook only reports itself there; moving the
+// cursor cancels it and previews the selection as usual.
+func (s *AppState) runDirHook() {
+	if !s.showPreview {
+		s.loadPreviewForSelection()
+		return
+	}
+	s.saveScroll()
+	if s.previewCancel != nil {
+		s.previewCancel()
+	}
+	s.previewCtx, s.previewCancel = context.WithCancel(context.Background())
+	s.previewPath = s.selectedPath()
+	gen := s.previewGen.Add(1)
+	dir := s.currentDir
+	ctx := s.previewCtx
+	s.preview.SetText("Running " + tview.Escape(DirHook) + "...")
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, DirHookTimeout)
+		defer cancel()
+		cmd := shellCommand(ctx, strings.ReplaceAll(DirHook, "{}", shellQuote(dir)))
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if len(out) > PreviewMaxBytes {
+			out = out[:PreviewMaxBytes]
+		}
+		text := tview.Escape(string(out))
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			text += fmt.Sprintf("\n[red]Hook timed out after %v[-]", DirHookTimeout)
+		case err != nil:
+			text += "\n[red]Hook failed: " + tview.Escape(err.Error()) + "[-]"
+		}
+		s.app.QueueUpdateDraw(func() {
+			if s.previewGen.Load() != gen || s.currentDir != dir {
+				return
+			}
+			s.preview.SetText(text).ScrollToBeginning()
+		})
+	}()
+}
+
+// shellCommand runs line through the platform's shell.
+func shellCommand(ctx context.Context, line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", line)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", line)
+}
+
+// shellQuote quotes path as a single word for shellCommand.
+func shellQuote(path string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + path + `"`
+	}
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+func (s *AppState) onEnter(path string) {
+	if target, ok := brokenLink(path); ok {
+		s.showModal("Cannot open "+filepath.Base(path)+": broken symlink to "+target, []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		s.changeDir(path)
+		return
+	}
+	// file: preview or open
+	if EnterOpensFile {
+		if err := systemOpen(path); err != nil {
+			s.showModal("Open failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		}
+		return
+	}
+	s.openPreview(path)
+}
+
+// brokenLink reports whether path is a symlink whose target is missing, and
+// returns the target it points at.
+func brokenLink(path string) (string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return "", false
+	}
+	if _, err := os.Stat(path); err == nil {
+		return "", false
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		target = "?"
+	}
+	return target, true
+}
+
+func (s *AppState) openPreview(path string) {
+	if !s.showPreview {
+		return
+	}
+	// open in system default if small binary? we provide both options. Default: preview if text
+	if isTextFile(path) {
+		ctx := s.previewCtx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		go s.loadTextPreview(ctx, s.previewGen.Add(1), path)
+	} else {
+		s.preview.Clear()
+		s.preview.SetText("(No text preview available. Press 'o' to open with system default.)")
+	}
+}
+
+// loadSelectionInfo fills in the status bar's details of path, the entry
+// under the cursor: its name and modification time, with its size, or how
+// many entries it holds for a directory. The stat runs in the background.
+func (s *AppState) loadSelectionInfo(path string) {
+	gen := s.selInfoGen.Add(1)
+	if path == "" {
+		s.selInfo = ""
+		s.renderStatus()
+		return
+	}
+	go func() {
+		info, err := os.Stat(path)
 		if err != nil {
-			s.showModal("Rename failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
-			return
+			// a broken link still has a name and time of its own
+			info, err = os.Lstat(path)
 		}
-		s.updateStatus("Renamed to: " + text)
-		s.refreshList()
-	})
+		text := "[::b]" + tview.Escape(filepath.Base(path)) + "[::-]"
+		if err == nil {
+			size := humanSize(info.Size())
+			if info.IsDir() {
+				size = "? items"
+				if f, err := os.Open(path); err == nil {
+					names, _ := f.Readdirnames(-1)
+					f.Close()
+					size = fmt.Sprintf("%d items", len(names))
+					if len(names) == 1 {
+						size = "1 item"
+					}
+				}
+			}
+			text += fmt.Sprintf(", %s, %s", size, formatModTime(info.ModTime()))
+		}
+		s.app.QueueUpdateDraw(func() {
+			if s.selInfoGen.Load() != gen || s.selectedPath() != path {
+				return
+			}
+			s.selInfo = text
+			s.renderStatus()
+		})
+	}()
 }
 
-func (s *AppState) copySelection() {
-	idx := s.filesList.GetCurrentItem()
-	if idx < 0 {
+// loadPreviewForSelection must run on the UI goroutine; any file system
+// access is pushed to a background goroutine so slow mounts don't stall
+// navigation.
+func (s *AppState) loadPreviewForSelection() {
+	s.loadSelectionInfo(s.selectedPath())
+	// nothing to load while the pane is hidden
+	path := ""
+	if s.showPreview {
+		path = s.selectedPath()
+	}
+	if path != s.previewPath || s.previewCtx == nil {
+		if s.previewCancel != nil {
+			s.previewCancel()
+		}
+		s.previewCtx, s.previewCancel = context.WithCancel(context.Background())
+		s.previewPath = path
+	}
+	if !s.showPreview {
 		return
 	}
-	label, _ := s.filesList.GetItemText(idx)
-	name := strings.TrimPrefix(label, "[::b][DIR] ")
-	s.askInput("Copy to", "Destination path:", filepath.Join(s.currentDir, name+".copy"), func(text string, ok bool) {
-		if !ok || strings.TrimSpace(text) == "" {
+	s.saveScroll()
+	if path == "" {
+		s.preview.SetText("")
+		return
+	}
+	s.preview.SetText("Loading...")
+	go s.loadPreview(s.previewCtx, s.previewGen.Add(1), path)
+}
+
+// previewFile identifies the version of a file shown in the preview.
+type previewFile struct {
+	path string
+	mod  time.Time
+	size int64
+}
+
+// scrollMemory remembers how far the preview of each file was scrolled,
+// for the PreviewScrollMemory most recently left files. It is only used on
+// the UI goroutine.
+type scrollMemory struct {
+	rows  map[previewFile]int
+	order []previewFile // least recently saved first
+}
+
+func (m *scrollMemory) save(f previewFile, row int) {
+	if m.rows == nil {
+		m.rows = make(map[previewFile]int)
+	}
+	m.forget(f.path)
+	if row == 0 {
+		return
+	}
+	m.rows[f] = row
+	m.order = append(m.order, f)
+	if len(m.order) > PreviewScrollMemory {
+		delete(m.rows, m.order[0])
+		m.order = m.order[1:]
+	}
+}
+
+// row returns the saved offset for f; a file that changed since has none.
+func (m *scrollMemory) row(f previewFile) int {
+	return m.rows[f]
+}
+
+func (m *scrollMemory) forget(path string) {
+	for i, have := range m.order {
+		if have.path == path {
+			delete(m.rows, have)
+			m.order = append(m.order[:i], m.order[i+1:]...)
 			return
 		}
-		s.updateStatus("Copying...")
-		err := copyPath(filepath.Join(s.currentDir, name), text)
-		if err != nil {
-			s.showModal("Copy failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+	}
+}
+
+// saveScroll records the scroll offset of the text file in the preview
+// before the preview is replaced.
+func (s *AppState) saveScroll() {
+	if s.previewShown.path == "" {
+		return
+	}
+	row, _ := s.preview.GetScrollOffset()
+	s.scrolls.save(s.previewShown, max(row-s.previewOffset, 0))
+	s.previewShown = previewFile{}
+	s.previewOffset = 0
+}
+
+// selectedPath returns the full path of the entry under the cursor, or ""
+// when the list is empty or the cursor is on the "Go up" row.
+func (s *AppState) selectedPath() string {
+	name := s.nameAt(s.filesList.GetCurrentItem())
+	if name == "" || name == goUpName {
+		return ""
+	}
+	return filepath.Join(s.currentDir, name)
+}
+
+// nameAt returns the file name shown at list index i, goUpName for the
+// "Go up" row, or "" if i is out of range. Names are kept alongside the
+// items so they never have to be parsed back out of the display labels.
+func (s *AppState) nameAt(i int) string {
+	if i < 0 || i >= len(s.itemNames) {
+		return ""
+	}
+	return s.itemNames[i]
+}
+
+// loadPreview stats path off the UI goroutine and fills the preview with
+// either the text contents or the file's metadata. ctx is cancelled once the
+// selection moves away from path.
+func (s *AppState) loadPreview(ctx context.Context, gen uint64, path string) {
+	if target, ok := brokenLink(path); ok {
+		s.setPreviewFor(gen, path, "broken symlink → "+tview.Escape(target))
+		return
+	}
+	info, err := os.Stat(path)
+	if err == nil && info.IsDir() {
+		s.loadDirPreview(ctx, gen, path, info)
+		return
+	}
+	if isTextFile(path) {
+		if render := previewRenderers[strings.ToLower(filepath.Ext(path))]; render != nil && !s.rawPreview.Load() {
+			s.loadRenderedPreview(ctx, gen, path, render)
 			return
 		}
-		s.updateStatus("Copied to: " + text)
-		s.refreshList()
+		s.loadTextPreview(ctx, gen, path)
+		return
+	}
+	if err == nil && info.Mode().IsRegular() && decompressors[strings.ToLower(filepath.Ext(path))].open != nil {
+		// loadTextPreview falls back to the metadata if what is inside
+		// is not text
+		s.loadTextPreview(ctx, gen, path)
+		return
+	}
+	s.loadFileInfo(gen, path, info, err)
+}
+
+// loadFileInfo shows the metadata of path, a file that is not previewed as
+// text. info and err are what stat returned for it.
+func (s *AppState) loadFileInfo(gen uint64, path string, info fs.FileInfo, err error) {
+	name := filepath.Base(path)
+	if err != nil {
+		s.setPreviewFor(gen, path, "(Unable to stat file)")
+		return
+	}
+	text := fmt.Sprintf("%s\nSize: %s\nModified: %s", tview.Escape(name), humanSize(info.Size()), formatModTime(info.ModTime()))
+	if kind, err := detectType(path); err == nil {
+		text += "\nType: " + kind
+	}
+	if n, ok := linkCount(info); ok && n > 1 {
+		text += fmt.Sprintf("\nHard links: %d", n)
+	}
+	text += "\n" + describeMode(info.Mode())
+	if names, err := xattrNames(path); err == nil && len(names) > 0 {
+		text += "\nExtended attributes: " + tview.Escape(strings.Join(names, ", "))
+		if hasACL(names) {
+			text += "\nACL: present"
+		}
+	}
+	if MediaProbe && isMediaFile(path) {
+		s.setPreviewFor(gen, path, text+"\n\nProbing media...")
+		if media, err := probeMedia(path, info); err == nil {
+			text += "\n\n" + tview.Escape(media)
+		}
+	}
+	s.setPreviewFor(gen, path, text)
+}
+
+// loadDirPreview summarises a directory: its immediate files and
+// subdirectories right away, then the recursive size once it is known.
+func (s *AppState) loadDirPreview(ctx context.Context, gen uint64, path string, info fs.FileInfo) {
+	text := "[DIR[] " + tview.Escape(filepath.Base(path))
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		s.setPreviewFor(gen, path, text+"\n\n(Unable to read directory: "+tview.Escape(err.Error())+")")
+		return
+	}
+	var files, dirs int
+	for _, e := range entries {
+		if e.IsDir() {
+			dirs++
+		} else {
+			files++
+		}
+	}
+	text += fmt.Sprintf("\n%d files, %d folders\nModified: %s", files, dirs, formatModTime(info.ModTime()))
+
+	if size, ok := cachedDirSize(path, info); ok {
+		s.setPreviewFor(gen, path, text+"\nTotal size: "+humanSize(size))
+		return
+	}
+	s.setPreviewFor(gen, path, text+"\nTotal size: calculating...")
+	progress := make(chan int64)
+	shown := make(chan struct{})
+	go func() {
+		defer close(shown)
+		for n := range progress {
+			s.setPreviewFor(gen, path, text+"\nTotal size: calculating... "+humanSize(n)+" so far")
+		}
+	}()
+	size, err := dirSize(ctx, path, progress)
+	// let the last progress update land before the result
+	<-shown
+	if err != nil {
+		if ctx.Err() == nil {
+			s.setPreviewFor(gen, path, text+"\nTotal size: unknown ("+tview.Escape(err.Error())+")")
+		}
+		return
+	}
+	s.setPreviewFor(gen, path, text+"\nTotal size: "+humanSize(size))
+}
+
+type dirSizeEntry struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// dirSizeCacheMax bounds how many directory sizes are remembered; the least
+// recently used one is dropped to make room.
+const dirSizeCacheMax = 256
+
+var (
+	dirSizeCacheLock sync.Mutex
+	dirSizeCache     = make(map[string]*list.Element) // values are dirSizeEntry
+	dirSizeLRU       = list.New()                     // most recently used first
+)
+
+// cachedDirSize returns the remembered recursive size of path if the
+// directory hasn't been modified since. Only the directory's own modtime is
+// checked, so changes deeper down are picked up once something is added or
+// removed at the top level. A stale entry is dropped.
+func cachedDirSize(path string, info fs.FileInfo) (int64, bool) {
+	dirSizeCacheLock.Lock()
+	defer dirSizeCacheLock.Unlock()
+	el, ok := dirSizeCache[path]
+	if !ok {
+		return 0, false
+	}
+	e := el.Value.(dirSizeEntry)
+	if !e.modTime.Equal(info.ModTime()) {
+		dirSizeLRU.Remove(el)
+		delete(dirSizeCache, path)
+		return 0, false
+	}
+	dirSizeLRU.MoveToFront(el)
+	return e.size, true
+}
+
+func storeDirSize(path string, info fs.FileInfo, size int64) {
+	dirSizeCacheLock.Lock()
+	defer dirSizeCacheLock.Unlock()
+	e := dirSizeEntry{path: path, modTime: info.ModTime(), size: size}
+	if el, ok := dirSizeCache[path]; ok {
+		el.Value = e
+		dirSizeLRU.MoveToFront(el)
+		return
+	}
+	dirSizeCache[path] = dirSizeLRU.PushFront(e)
+	if dirSizeLRU.Len() > dirSizeCacheMax {
+		oldest := dirSizeLRU.Back()
+		dirSizeLRU.Remove(oldest)
+		delete(dirSizeCache, oldest.Value.(dirSizeEntry).path)
+	}
+}
+
+// detectType classifies a file by its first 512 bytes, so a misnamed file
+// still shows what it really is.
+func detectType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if n == 0 {
+		return "empty", nil
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// describeMode shows a file mode both symbolically and in octal, followed
+// by any setuid/setgid/sticky bits spelled out.
+func describeMode(mode fs.FileMode) string {
+	octal := uint32(mode.Perm())
+	var special []string
+	if mode&fs.ModeSetuid != 0 {
+		octal |= 0o4000
+		special = append(special, "setuid")
+	}
+	if mode&fs.ModeSetgid != 0 {
+		octal |= 0o2000
+		special = append(special, "setgid")
+	}
+	if mode&fs.ModeSticky != 0 {
+		octal |= 0o1000
+		special = append(special, "sticky")
+	}
+	text := fmt.Sprintf("Mode: %s (%04o)", mode, octal)
+	if len(special) > 0 {
+		text += "\nSpecial bits: " + strings.Join(special, ", ")
+	}
+	return text
+}
+
+// hasACL reports whether any of the extended attribute names hold an access
+// control list.
+func hasACL(names []string) bool {
+	for _, name := range names {
+		if strings.HasPrefix(name, "system.posix_acl_") || name == "com.apple.acl.text" || name == "system.nfs4_acl" {
+			return true
+		}
+	}
+	return false
+}
+
+// setPreviewFor shows text in the preview unless it is stale: a newer
+// preview was started after gen, or the selection has moved away from path
+// while it was being produced.
+func (s *AppState) setPreviewFor(gen uint64, path, text string) {
+	s.app.QueueUpdateDraw(func() {
+		if s.previewStale(gen, path) {
+			return
+		}
+		s.preview.SetText(text)
+	})
+}
+
+// previewStale must run on the UI goroutine.
+func (s *AppState) previewStale(gen uint64, path string) bool {
+	return s.previewGen.Load() != gen || s.selectedPath() != path
+}
+
+// previewFirstLines is how much of a text file is read before anything is
+// shown, roughly a screenful.
+const previewFirstLines = 100
+
+// decompressors open the compression wrappers whose text is previewed, by
+// extension. There is no xz reader in the standard library, so .xz files
+// still only show their metadata.
+var decompressors = map[string]struct {
+	name string
+	open func(io.Reader) (io.Reader, error)
+}{
+	".gz":  {"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	".bz2": {"bzip2", func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil }},
+}
+
+// loadTextPreview streams the start of a text file into the preview: the
+// first screenful is shown as soon as it is read and the rest is appended
+// in chunks at most every 100ms. It stops once ctx is cancelled. A file in
+// one of the decompressors' wrappers is previewed decompressed, or by its
+// metadata if what is inside is not text. The stats header shows a
+// placeholder until the lines have been counted.
+func (s *AppState) loadTextPreview(ctx context.Context, gen uint64, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		s.setPreviewFor(gen, path, previewError("open", err))
+		return
+	}
+	defer f.Close()
+	shown := previewFile{path: path}
+	info, statErr := f.Stat()
+	if statErr == nil {
+		shown.mod, shown.size = info.ModTime(), info.Size()
+		if info.Mode().IsRegular() && info.Size() == 0 {
+			s.setPreviewFor(gen, path, "[gray](empty file)[-]")
+			return
+		}
+	}
+
+	var chunk strings.Builder
+	first := true
+	headerRows := 0
+	var src io.Reader = f
+	decompressed := ""
+	wrapper := decompressors[strings.ToLower(filepath.Ext(path))]
+	if wrapper.open != nil {
+		r, err := wrapper.open(f)
+		if err != nil {
+			s.setPreviewFor(gen, path, previewError("decompress", err))
+			return
+		}
+		src = r
+		decompressed = fmt.Sprintf("[gray]Decompressed from %s (%s packed)[-]\n\n", wrapper.name, humanSize(shown.size))
+		headerRows = 2
+	}
+	// the stats header can only count the lines once they have all been
+	// read, so until then it holds a placeholder
+	header := s.previewHeader.Load()
+	var read bytes.Buffer    // the text read, for the header's counts
+	var body strings.Builder // the text shown under the headers
+	cut := false             // a read error stopped the counts short
+	if header {
+		headerRows += 2
+	}
+	limit := previewLimit(path)
+	flush := func(done bool) {
+		text, replace := chunk.String(), first
+		chunk.Reset()
+		first = false
+		if header {
+			body.WriteString(text)
+		}
+		rewrite := done && header
+		switch {
+		case rewrite:
+			text = decompressed + previewHeaderLine(path, shown.size, read.Bytes(), cut || read.Len() == limit) + body.String()
+		case replace && header:
+			text = decompressed + previewHeaderPending(path, shown.size) + text
+		case replace:
+			text = decompressed + text
+		}
+		s.app.QueueUpdateDraw(func() {
+			if ctx.Err() != nil || s.previewStale(gen, path) {
+				return
+			}
+			if replace {
+				s.preview.SetText(text)
+				s.previewShown = shown
+				s.previewOffset = headerRows
+			} else if rewrite {
+				row, col := s.preview.GetScrollOffset()
+				s.preview.SetText(text)
+				s.preview.ScrollTo(row, col)
+			} else {
+				// appending keeps the reader's scroll position
+				fmt.Fprint(s.preview, text)
+			}
+			if !done {
+				return
+			}
+			if s.lastMatch.path == path && s.lastMatch.line > 0 {
+				// a search hit wins once; after that the position the
+				// user leaves the file at is remembered as usual
+				s.preview.ScrollTo(s.lastMatch.line-1+headerRows, 0)
+				s.lastMatch = searchResult{}
+			} else if row := s.scrolls.row(shown); row > 0 {
+				s.preview.ScrollTo(row+headerRows, 0)
+			}
+		})
+	}
+
+	// for a compressed file the limit applies to what comes out of it, so
+	// a small file that inflates enormously is cut off all the same
+	reader := bufio.NewReaderSize(io.LimitReader(src, int64(limit)), binarySniffBytes)
+	if sample, _ := reader.Peek(binarySniffBytes); looksBinary(sample) {
+		if wrapper.open != nil {
+			s.loadFileInfo(gen, path, info, statErr)
+			return
+		}
+		data, _ := io.ReadAll(io.LimitReader(reader, hexPreviewBytes))
+		s.setPreviewFor(gen, path, fmt.Sprintf("[gray]Binary content; the first %d bytes as hex:[-]\n\n%s", len(data), tview.Escape(hex.Dump(data))))
+		return
+	}
+	n, lines := 0, 0
+	lastFlush := time.Now()
+	more := true
+	for lines < TextPreviewLines {
+		line, err := reader.ReadString('\n')
+		chunk.WriteString(tview.Escape(sanitizeText(line)))
+		if header {
+			read.WriteString(line)
+		}
+		n += len(line)
+		lines++
+		if err != nil {
+			if err != io.EOF {
+				chunk.WriteString("\n\n" + previewError("read", err))
+				cut = true
+			}
+			more = false
+			break
+		}
+		if (first && lines >= previewFirstLines) || (!first && time.Since(lastFlush) > 100*time.Millisecond) {
+			if ctx.Err() != nil {
+				return
+			}
+			flush(false)
+			lastFlush = time.Now()
+		}
+	}
+	if n == limit {
+		chunk.WriteString("\n... (truncated)")
+	}
+	if header && more {
+		// past the lines shown, the rest is only read for the counts
+		flush(false)
+		if _, err := read.ReadFrom(reader); err != nil {
+			cut = true
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	flush(true)
+}
+
+// A text file whose first binarySniffBytes look binary is previewed as a
+// hex dump of its first hexPreviewBytes instead.
+const (
+	binarySniffBytes = 8192
+	hexPreviewBytes  = 4096
+)
+
+// looksBinary reports whether sample holds a NUL byte or is more than a
+// tenth control characters other than whitespace.
+func looksBinary(sample []byte) bool {
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+	controls := 0
+	for _, b := range sample {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			controls++
+		}
+	}
+	return controls*10 > len(sample)
+}
+
+// sanitizeText replaces control characters, which could move the cursor or
+// change the terminal's state, and invalid UTF-8 with U+FFFD. Tabs and
+// line breaks stay, and the CR of a CRLF is dropped.
+func sanitizeText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return utf8.RuneError
+	}, strings.ToValidUTF8(text, string(utf8.RuneError)))
+}
+
+// previewLimit is how many bytes of path the preview reads.
+func previewLimit(path string) int {
+	if limit, ok := PreviewMaxBytesByExt[strings.ToLower(filepath.Ext(path))]; ok {
+		return limit
+	}
+	return PreviewMaxBytes
+}
+
+// previewHeaderLine sums up a text file above its preview: name, size,
+// line count and encoding. data is what was read of it; when truncated the
+// count is a lower bound, shown as "N+ lines".
+func previewHeaderLine(path string, size int64, data []byte, truncated bool) string {
+	lines := bytes.Count(data, []byte{'\n'})
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		lines++
+	}
+	count := fmt.Sprintf("%d lines", lines)
+	if truncated {
+		count = fmt.Sprintf("%d+ lines", lines)
+	} else if lines == 1 {
+		count = "1 line"
+	}
+	return fmt.Sprintf("[::b]%s[::-]  [gray]%s, %s, %s[-]\n\n", tview.Escape(filepath.Base(path)), humanSize(size), count, textEncoding(data, truncated))
+}
+
+// previewHeaderPending stands in for previewHeaderLine while the file is
+// still being read.
+func previewHeaderPending(path string, size int64) string {
+	return fmt.Sprintf("[::b]%s[::-]  [gray]%s, counting lines...[-]\n\n", tview.Escape(filepath.Base(path)), humanSize(size))
+}
+
+// textEncoding names the encoding data appears to be in. A truncated read
+// may end partway through a character, which is not held against it.
+func textEncoding(data []byte, truncated bool) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return "UTF-8 with BOM"
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "UTF-16"
+	}
+	if truncated {
+		// drop an incomplete last character
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					data = data[:i]
+				}
+				break
+			}
+		}
+	}
+	if !utf8.Valid(data) {
+		return "not UTF-8"
+	}
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return "UTF-8"
+		}
+	}
+	return "ASCII"
+}
+
+// previewError formats a failure to open or read a previewed file, with a
+// plainer message for the common case of missing permission.
+func previewError(op string, err error) string {
+	if errors.Is(err, fs.ErrPermission) {
+		return "[red]Permission denied:[-] you cannot " + op + " this file"
+	}
+	return "[red]Cannot " + op + " file:[-] " + tview.Escape(err.Error())
+}
+
+// previewRenderers turn the source of structured files, by extension, into
+// a view for the preview pane. The result may hold color tags, so any text
+// from the file must be escaped.
+var previewRenderers = map[string]func(path string, data []byte) (string, error){
+	".json":     renderJSON,
+	".csv":      renderCSV,
+	".tsv":      renderCSV,
+	".md":       renderMarkdown,
+	".markdown": renderMarkdown,
+}
+
+// loadRenderedPreview shows a structured file through render. Files over
+// their preview limit, or that render rejects, are shown as plain text
+// instead.
+func (s *AppState) loadRenderedPreview(ctx context.Context, gen uint64, path string, render func(string, []byte) (string, error)) {
+	f, err := os.Open(path)
+	if err != nil {
+		s.setPreviewFor(gen, path, previewError("open", err))
+		return
+	}
+	limit := previewLimit(path)
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	f.Close()
+	if err == nil && len(data) > 0 && len(data) <= limit && ctx.Err() == nil {
+		if text, err := render(path, data); err == nil {
+			s.setPreviewFor(gen, path, sanitizeText(text))
+			return
+		}
+	}
+	s.loadTextPreview(ctx, gen, path)
+}
+
+// togglePreviewHeader shows or hides the stats line above text previews.
+func (s *AppState) togglePreviewHeader() {
+	on := !s.previewHeader.Load()
+	s.previewHeader.Store(on)
+	if on {
+		s.updateStatus("Preview stats line shown")
+	} else {
+		s.updateStatus("Preview stats line hidden")
+	}
+	s.loadPreviewForSelection()
+}
+
+// toggleRawPreview flips structured files between their rendered view and
+// their source, for every file until toggled back.
+func (s *AppState) toggleRawPreview() {
+	raw := !s.rawPreview.Load()
+	s.rawPreview.Store(raw)
+	if raw {
+		s.updateStatus("Previewing structured files as source")
+	} else {
+		s.updateStatus("Previewing structured files rendered")
+	}
+	s.loadPreviewForSelection()
+}
+
+func renderJSON(_ string, data []byte) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return "", err
+	}
+	return tview.Escape(buf.String()), nil
+}
+
+// csvCellWidth caps how wide a rendered CSV column gets.
+const csvCellWidth = 30
+
+// renderCSV lays the records out as a table with the first row as header.
+func renderCSV(path string, data []byte) (string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	if strings.EqualFold(filepath.Ext(path), ".tsv") {
+		r.Comma = '\t'
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return "", err
+	}
+	var widths []int
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], min(runewidth.StringWidth(cell), csvCellWidth))
+		}
+	}
+	var b strings.Builder
+	for n, row := range rows {
+		for i, cell := range row {
+			cell = runewidth.Truncate(cell, csvCellWidth, "…")
+			// pad by the width before escaping adds hidden brackets
+			cell = tview.Escape(cell) + strings.Repeat(" ", widths[i]-runewidth.StringWidth(cell))
+			if n == 0 {
+				cell = "[::b]" + cell + "[::-]"
+			}
+			if i > 0 {
+				b.WriteString(" [gray]│[-] ")
+			}
+			b.WriteString(cell)
+		}
+		b.WriteByte('\n')
+		if n == 0 {
+			for i, w := range widths {
+				if i > 0 {
+					b.WriteString("─┼─")
+				}
+				b.WriteString(strings.Repeat("─", w))
+			}
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), nil
+}
+
+var (
+	mdBold = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	mdCode = regexp.MustCompile("`([^`]+)`")
+)
+
+// renderMarkdown styles headings, lists, quotes, code and bold text. It
+// reads the source line by line and does not try to be a full renderer.
+func renderMarkdown(_ string, data []byte) (string, error) {
+	var b strings.Builder
+	fenced := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+			continue
+		}
+		line = tview.Escape(line)
+		if fenced {
+			b.WriteString("  [gray]" + line + "[-]\n")
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " ")
+		indent := line[:len(line)-len(trimmed)]
+		switch {
+		case strings.HasPrefix(trimmed, "#"):
+			b.WriteString("[yellow::b]" + strings.TrimSpace(strings.TrimLeft(trimmed, "#")) + "[-::-]\n")
+			continue
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+			line = indent + "• " + trimmed[2:]
+		case strings.HasPrefix(trimmed, ">"):
+			line = indent + "[gray]│[-] " + strings.TrimSpace(trimmed[1:])
+		}
+		line = mdBold.ReplaceAllString(line, "[::b]$1[::-]")
+		line = mdCode.ReplaceAllString(line, "[gray]$1[-]")
+		b.WriteString(line + "\n")
+	}
+	return b.String(), nil
+}
+
+// updateStatus shows msg in the status bar. It may be called from any
+// goroutine: QueueUpdateDraw waits for the event loop, which would never come
+// round if the caller were the event loop itself, so the redraw is queued from
+// a goroutine of its own and shows whichever message was set last.
+func (s *AppState) updateStatus(msg string) {
+	s.pendingStatus.Store(&msg)
+	go s.app.QueueUpdateDraw(func() {
+		if p := s.pendingStatus.Swap(nil); p != nil {
+			s.statusMsg = *p
+			s.renderStatus()
+		}
+	})
+}
+
+// renderStatus redraws the status bar with the last message. It must run on
+// the UI goroutine.
+func (s *AppState) renderStatus() {
+	dir := tview.Escape(s.currentDir)
+	if s.isBookmarked(s.currentDir) {
+		dir += " [yellow]★[-]"
+	}
+	sel := ""
+	if s.selInfo != "" {
+		sel = "  [green]|[-] " + s.selInfo
+	}
+	s.status.SetText(fmt.Sprintf("[yellow]Dir:[-] %s%s  [green]|[-] %s%s", dir, sel, tview.Escape(s.statusMsg), s.statusFlags()))
+}
+
+// statusFlags describes the active view options for the status bar.
+func (s *AppState) statusFlags() string {
+	var flags []string
+	sortDesc := "sort: " + s.sortMode.String()
+	if s.sortReverse {
+		sortDesc += " (reversed)"
+	}
+	flags = append(flags, sortDesc)
+	if s.dirsFirst {
+		flags = append(flags, "dirs first")
+	}
+	if s.showType != showAll {
+		flags = append(flags, "show: "+s.showType.String())
+	}
+	if s.filter.active() {
+		desc := s.filter.mode() + " filter: " + tview.Escape(s.filter.term)
+		if s.filter.invert {
+			desc += " [red](inverted)[-]"
+		}
+		flags = append(flags, desc)
+	}
+	if n := len(s.selected); n > 0 {
+		flags = append(flags, fmt.Sprintf("%d selected", n))
+	}
+	if s.tagFilter != "" {
+		flags = append(flags, "tag: ["+s.tagFilter+"]"+s.tagFilter+"[-]")
+	}
+	if len(s.tabs) > 1 {
+		flags = append(flags, fmt.Sprintf("tab %d/%d", s.tabIndex+1, len(s.tabs)))
+	}
+	if !s.followLinks {
+		flags = append(flags, "links not followed")
+	}
+	if s.dryRun {
+		flags = append(flags, "[red]dry run[-]")
+	}
+	if s.readOnly {
+		flags = append(flags, "[red]read-only[-]")
+	}
+	if running, queued := s.ops.counts(); running+queued > 0 {
+		flags = append(flags, fmt.Sprintf("[yellow]ops: %d running, %d queued[-]", running, queued))
+	}
+	if s.hiddenCount > 0 {
+		flags = append(flags, fmt.Sprintf("(%d hidden)", s.hiddenCount))
+	}
+	if len(flags) == 0 {
+		return ""
+	}
+	return "  [green]|[-] " + strings.Join(flags, ", ")
+}
+
+// showModal shows message as plain text; color tags in it are escaped, so
+// file names can be passed in as they are.
+func (s *AppState) showModal(message string, buttons []string, done func(int, string)) {
+	s.showDialog(message, buttons, done)
+}
+
+// modalMaxLines is how many wrapped lines of text a tview.Modal is given;
+// longer messages get the wider, scrollable messageDialog.
+const modalMaxLines = 6
+
+// showDialog shows message with buttons and calls done with the one chosen,
+// or with -1 and "" for Esc. Short messages get a tview.Modal; long or
+// multi-line ones, often errors with full paths in them, get a dialog two
+// thirds of the screen wide whose text wraps and scrolls.
+func (s *AppState) showDialog(message string, buttons []string, done func(int, string)) {
+	finish := func(index int, label string) {
+		// restore layout before handing control back
+		_ = s.app.SetRoot(s.layout(), true)
+		done(index, label)
+	}
+	width, height := 80, 24
+	if s.screen != nil {
+		width, height = s.screen.Size()
+	}
+	if !strings.Contains(message, "\n") && len(tview.WordWrap(tview.Escape(message), width/3)) <= modalMaxLines {
+		modal := tview.NewModal().SetText(tview.Escape(message)).AddButtons(buttons).SetDoneFunc(finish)
+		_ = s.app.SetRoot(modal, true)
+		return
+	}
+	_ = s.app.SetRoot(s.messageDialog(message, buttons, width, height, finish), true)
+}
+
+// messageDialog lays out message, as plain text, above a row of buttons,
+// centered on a width by height screen. Up, Down, PgUp and PgDn scroll the
+// text while the buttons have focus.
+func (s *AppState) messageDialog(message string, buttons []string, width, height int, done func(int, string)) tview.Primitive {
+	text := tview.NewTextView().SetText(message).SetWrap(true).SetWordWrap(true).SetScrollable(true)
+	form := tview.NewForm().SetButtonsAlign(tview.AlignCenter)
+	for i, label := range buttons {
+		form.AddButton(label, func() { done(i, label) })
+	}
+	form.SetCancelFunc(func() { done(-1, "") })
+	form.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			text.InputHandler()(event, func(p tview.Primitive) {})
+			return nil
+		}
+		return event
+	})
+	box := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(text, 0, 1, false).
+		AddItem(form, 3, 0, true)
+	box.SetBorder(true)
+
+	boxWidth := min(max(width*2/3, 40), width)
+	lines := len(tview.WordWrap(tview.Escape(message), boxWidth-2))
+	boxHeight := min(lines+2+3, max(height-2, 8))
+	row := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(box, boxWidth, 0, true).
+		AddItem(nil, 0, 1, false)
+	return tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(row, boxHeight, 0, true).
+		AddItem(nil, 0, 1, false)
+}
+
+// Marking
+
+// toggleMark flips the mark on the entry under the cursor and moves down,
+// so a run of files can be marked by holding the key.
+func (s *AppState) toggleMark() {
+	idx := s.filesList.GetCurrentItem()
+	path := s.selectedPath()
+	if path == "" {
+		return
+	}
+	name := filepath.Base(path)
+	if s.selected[path] {
+		delete(s.selected, path)
+	} else {
+		s.selected[path] = true
+	}
+	s.lock.Lock()
+	for _, e := range s.files {
+		if e.Name() == name {
+			s.filesList.SetItemText(idx, s.formatItem(e, s.nameWidth), "")
+			break
+		}
+	}
+	s.lock.Unlock()
+	if idx+1 < s.filesList.GetItemCount() {
+		s.filesList.SetCurrentItem(idx + 1)
+	}
+	s.renderStatus()
+}
+
+// togglePin pins the entry under the cursor to the top of its directory,
+// or unpins it. Pins are saved straight away.
+func (s *AppState) togglePin() {
+	path := s.selectedPath()
+	if path == "" {
+		return
+	}
+	if s.pins[path] {
+		delete(s.pins, path)
+		s.updateStatus("Unpinned " + filepath.Base(path))
+	} else {
+		s.pins[path] = true
+		s.updateStatus("Pinned " + filepath.Base(path))
+	}
+	s.prefs.Pins = slices.Sorted(maps.Keys(s.pins))
+	if err := s.prefs.save(); err != nil {
+		s.updateStatus("Could not save pins: " + err.Error())
+	}
+	s.resort()
+}
+
+// isMarked reports whether the entry called name in currentDir is marked.
+func (s *AppState) isMarked(name string) bool {
+	return s.selected[filepath.Join(s.currentDir, name)]
+}
+
+// markedPaths returns the full paths of the marked entries, from every
+// directory, in path order.
+func (s *AppState) markedPaths() []string {
+	paths := make([]string, 0, len(s.selected))
+	for path := range s.selected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// listMarked shows the marks from every directory. Choosing one jumps to
+// it; the first row clears them all.
+func (s *AppState) listMarked() {
+	paths := s.markedPaths()
+	if len(paths) == 0 {
+		s.showModal("Nothing is marked", []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	list := tview.NewList()
+	list.AddItem(fmt.Sprintf("[red]Clear all %d marks[-]", len(paths)), "", 0, func() {
+		_ = s.app.SetRoot(s.layout(), true)
+		s.selected = make(map[string]bool)
+		s.updateStatus("Marks cleared")
+		s.rebuildList(s.filesList.GetCurrentItem())
+	})
+	for _, p := range paths {
+		p := p
+		list.AddItem(tview.Escape(p), "", 0, func() {
+			_ = s.app.SetRoot(s.layout(), true)
+			if _, err := os.Lstat(p); err != nil {
+				delete(s.selected, p)
+				s.showModal("Marked entry is gone: "+p, []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+			s.revealPath(p)
+		})
+	}
+	list.SetDoneFunc(func() { _ = s.app.SetRoot(s.layout(), true) })
+	list.SetBorder(true).SetTitle("Marked")
+	_ = s.app.SetRoot(list, true)
+}
+
+// File operations
+
+func (s *AppState) revealSelection() {
+	path := s.selectedPath()
+	if path == "" {
+		path = s.currentDir
+	}
+	if err := systemReveal(path); err != nil {
+		s.showModal("Reveal failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+	}
+}
+
+// editSelection opens the entry under the cursor in the user's editor,
+// at the line the preview is scrolled to (or the search hit that led
+// here) when the editor supports it. The UI is suspended meanwhile.
+func (s *AppState) editSelection() {
+	path := s.selectedPath()
+	if path == "" {
+		return
+	}
+	line := 0
+	if s.showPreview && s.previewPath == path && isTextFile(path) {
+		row, _ := s.preview.GetScrollOffset()
+		line = max(row-s.previewOffset, 0) + 1
+	}
+	if line <= 1 && s.lastMatch.path == path {
+		line = s.lastMatch.line
+	}
+	cmd := editorCommand(path, line)
+	var err error
+	s.app.Suspend(func() {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		err = cmd.Run()
+	})
+	if err != nil {
+		s.showModal("Editor failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	s.pendingSelect = filepath.Base(path)
+	s.refreshListThen(0, s.loadPreviewForSelection)
+}
+
+// editorCommand builds the command for editing path, at line if it is
+// above 1 and the editor's line style is known.
+func editorCommand(path string, line int) *exec.Cmd {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = Editor
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		args = []string{Editor}
+	}
+	name := strings.TrimSuffix(filepath.Base(args[0]), ".exe")
+	if line > 1 {
+		switch EditorLineStyles[name] {
+		case "+line":
+			args = append(args, "+"+strconv.Itoa(line), path)
+		case "file:line":
+			args = append(args, path+":"+strconv.Itoa(line))
+		case "--goto":
+			args = append(args, "--goto", path+":"+strconv.Itoa(line))
+		default:
+			args = append(args, path)
+		}
+	} else {
+		args = append(args, path)
+	}
+	return exec.Command(args[0], args[1:]...)
+}
+
+// openSelection opens the marked files with the system default, or the
+// entry under the cursor when nothing is marked. Failures are collected and
+// reported together.
+func (s *AppState) openSelection() {
+	paths := s.markedPaths()
+	if len(paths) == 0 {
+		path := s.selectedPath()
+		if path == "" {
+			return
+		}
+		if target, ok := brokenLink(path); ok {
+			s.showModal("Cannot open "+filepath.Base(path)+": broken symlink to "+target, []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		_ = systemOpen(path)
+		return
+	}
+	run := func() {
+		var failures []batchFailure
+		for _, p := range paths {
+			if target, ok := brokenLink(p); ok {
+				failures = append(failures, batchFailure{p, errors.New("broken symlink to " + target)})
+				continue
+			}
+			if err := systemOpen(p); err != nil {
+				failures = append(failures, batchFailure{p, err})
+			}
+		}
+		s.reportBatch(fmt.Sprintf("Opened %d of %d files", len(paths)-len(failures), len(paths)), failures)
+	}
+	if len(paths) > OpenManyThreshold {
+		s.confirm(fmt.Sprintf("Open %d files at once?", len(paths)), func(ok bool) {
+			if ok {
+				run()
+			}
+		})
+		return
+	}
+	run()
+}
+
+func (s *AppState) askInput(title, label, initial string, done func(text string, ok bool)) {
+	s.askInputField(title, tview.NewInputField().SetLabel(label).SetText(initial), done)
+}
+
+// askInputField is askInput for callers that need to customise the field.
+// It returns the form so the caller can update its title as the user types.
+func (s *AppState) askInputField(title string, input *tview.InputField, done func(text string, ok bool)) *tview.Form {
+	return s.askForm(title, []*tview.InputField{input}, func(ok bool) {
+		if !ok {
+			done("", false)
+			return
+		}
+		done(input.GetText(), true)
+	})
+}
+
+// askForm shows a form with several inputs and OK/Cancel buttons. Enter
+// in the last input is OK and Esc anywhere is Cancel, so an edit is only
+// ever applied or dropped on purpose.
+func (s *AppState) askForm(title string, inputs []*tview.InputField, done func(ok bool)) *tview.Form {
+	form := tview.NewForm()
+	for _, input := range inputs {
+		form.AddFormItem(input)
+	}
+	finish := func(ok bool) {
+		_ = s.app.SetRoot(s.layout(), true)
+		done(ok)
+	}
+	form.AddButton("OK", func() { finish(true) })
+	form.AddButton("Cancel", func() { finish(false) })
+	form.SetCancelFunc(func() { finish(false) })
+	form.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if item, _ := form.GetFocusedItemIndex(); event.Key() == tcell.KeyEnter && item == len(inputs)-1 {
+			finish(true)
+			return nil
+		}
+		return event
+	})
+	form.SetBorder(true).SetTitle(tview.Escape(title))
+	_ = s.app.SetRoot(form, true)
+	return form
+}
+
+func (s *AppState) confirm(message string, done func(bool)) {
+	s.showDialog(message, []string{"Yes", "No"}, func(_ int, label string) {
+		done(label == "Yes")
+	})
+}
+
+// deleteSelection deletes the marked entries, or the one under the
+// cursor, asking first as ConfirmDelete says. With UseTrash they are moved
+// to the trash instead, unless they are already in it.
+func (s *AppState) deleteSelection() {
+	idx := s.filesList.GetCurrentItem()
+	paths := s.markedPaths()
+	if len(paths) == 0 {
+		if path := s.selectedPath(); path != "" {
+			paths = []string{path}
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	what := "'" + filepath.Base(paths[0]) + "'"
+	if len(paths) > 1 {
+		what = fmt.Sprintf("%d entries", len(paths))
+	}
+	// each entry goes to the trash unless it is already in it, when it is
+	// deleted for good
+	toTrash := make([]bool, len(paths))
+	trashed := 0
+	for i, path := range paths {
+		toTrash[i] = UseTrash && !inTrash(path)
+		if toTrash[i] {
+			trashed++
+		}
+	}
+	opName := func(i int) string {
+		if toTrash[i] {
+			return "trash"
+		}
+		return "delete"
+	}
+	desc, done := "Delete "+what, "Deleted"
+	switch trashed {
+	case len(paths):
+		desc, done = "Trash "+what, "Moved to trash"
+	case 0:
+	default:
+		desc, done = "Trash or delete "+what, "Trashed or deleted"
+	}
+	remove := func() {
+		if s.dryRun {
+			plan := make([]string, len(paths))
+			for i, path := range paths {
+				plan[i] = path + " (" + opName(i) + ")"
+			}
+			s.reportDryRun("Would "+strings.ToLower(desc[:1])+desc[1:], plan)
+			return
+		}
+		s.selected = make(map[string]bool)
+		errs := make([]error, len(paths))
+		s.enqueue(desc, func(q *queuedOp) error {
+			for i, path := range paths {
+				if err := q.ctx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				if toTrash[i] {
+					errs[i] = moveToTrash(path)
+				} else {
+					errs[i] = os.RemoveAll(path)
+				}
+				q.setProgress(fmt.Sprintf("%d of %d entries", i+1, len(paths)))
+			}
+			return nil
+		}, func(error) {
+			var failures []batchFailure
+			for i, path := range paths {
+				s.logActivity(opName(i), path, errs[i])
+				if errs[i] != nil {
+					failures = append(failures, batchFailure{path, errs[i]})
+				}
+			}
+			switch {
+			case len(paths) > 1:
+				s.reportBatch(fmt.Sprintf("%s %d of %d entries", done, len(paths)-len(failures), len(paths)), failures)
+			case len(failures) > 0:
+				s.showModal("Delete failed: "+failures[0].err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			default:
+				s.updateStatus(done + ": " + what)
+			}
+			// keep the cursor where the deleted item was so repeated
+			// deletes walk down the list
+			s.refreshListSelect(idx)
+		})
+	}
+	if !confirmDelete(paths) {
+		remove()
+		return
+	}
+	question := "Delete " + what + "? This cannot be undone."
+	switch trashed {
+	case len(paths):
+		question = "Move " + what + " to the trash?"
+	case 0:
+	default:
+		question = fmt.Sprintf("Move %d of %s to the trash and delete the %d already in it? Those cannot be undone.", trashed, what, len(paths)-trashed)
+	}
+	s.confirm(question, func(ok bool) {
+		if ok {
+			remove()
+		}
+	})
+}
+
+// Trash
+
+// The trash keeps each deleted entry under files/<id> with its metadata in
+// info/<id>.json, inside the config directory.
+type trashInfo struct {
+	Path    string    `json:"path"`    // where the entry was deleted from
+	Deleted time.Time `json:"deleted"` // when it was deleted
+}
+
+type trashItem struct {
+	id   string
+	info trashInfo
+}
+
+func trashDir() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trash"), nil
+}
+
+// inTrash reports whether path is inside the trash, where deleting is for
+// good.
+func inTrash(path string) bool {
+	dir, err := trashDir()
+	return err == nil && (path == dir || isAncestor(dir, path))
+}
+
+// moveToTrash moves path into the trash and records where it came from.
+func moveToTrash(path string) error {
+	dir, err := trashDir()
+	if err != nil {
+		return err
+	}
+	files, infos := filepath.Join(dir, "files"), filepath.Join(dir, "info")
+	if err := os.MkdirAll(files, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(infos, 0700); err != nil {
+		return err
+	}
+	now := time.Now()
+	id := fmt.Sprintf("%d-%s", now.UnixNano(), filepath.Base(path))
+	data, err := json.Marshal(trashInfo{Path: path, Deleted: now})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(infos, id+".json"), data, 0600); err != nil {
+		return err
+	}
+	if err := movePath(path, filepath.Join(files, id)); err != nil {
+		_ = os.Remove(filepath.Join(infos, id+".json"))
+		return err
+	}
+	return nil
+}
+
+// trashItems lists what is in the trash, oldest first. Entries whose
+// metadata is missing are dated by their modification time.
+func trashItems() ([]trashItem, error) {
+	dir, err := trashDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "files"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var items []trashItem
+	for _, e := range entries {
+		item := trashItem{id: e.Name()}
+		data, err := os.ReadFile(filepath.Join(dir, "info", e.Name()+".json"))
+		if err == nil {
+			err = json.Unmarshal(data, &item.info)
+		}
+		if err != nil {
+			if info, err := e.Info(); err == nil {
+				item.info.Deleted = info.ModTime()
+			}
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].info.Deleted.Before(items[j].info.Deleted) })
+	return items, nil
+}
+
+// removeTrashItem deletes a trashed entry and its metadata for good.
+func removeTrashItem(id string) error {
+	dir, err := trashDir()
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(dir, "files", id)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, "info", id+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// name is what the entry was called before it was trashed.
+func (t trashItem) name() string {
+	if t.info.Path != "" {
+		return filepath.Base(t.info.Path)
+	}
+	if _, name, ok := strings.Cut(t.id, "-"); ok && name != "" {
+		return name
+	}
+	return t.id
+}
+
+// restoreTrashItem moves a trashed entry to dst, recreating any directories
+// above it that have gone since, and drops its metadata.
+func restoreTrashItem(id, dst string) error {
+	dir, err := trashDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := movePath(filepath.Join(dir, "files", id), dst); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, "info", id+".json")); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// purgeTrash applies TrashMaxAge and then TrashMaxSize, removing the oldest
+// entries first. It walks every trashed entry to size it, so it runs in the
+// background at startup.
+func purgeTrash(ctx context.Context) (int, error) {
+	items, err := trashItems()
+	if err != nil {
+		return 0, err
+	}
+	dir, err := trashDir()
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	var errs []error
+	remove := func(id string) {
+		if err := removeTrashItem(id); err != nil {
+			errs = append(errs, err)
+			return
+		}
+		removed++
+	}
+	var kept []trashItem
+	for _, item := range items {
+		if TrashMaxAge > 0 && time.Since(item.info.Deleted) > TrashMaxAge {
+			remove(item.id)
+		} else {
+			kept = append(kept, item)
+		}
+	}
+	if TrashMaxSize > 0 {
+		sizes := make([]int64, len(kept))
+		var total int64
+		for i, item := range kept {
+			size, err := dirSize(ctx, filepath.Join(dir, "files", item.id), nil)
+			if ctx.Err() != nil {
+				return removed, ctx.Err()
+			}
+			if err == nil {
+				sizes[i] = size
+				total += size
+			}
+		}
+		for i := 0; i < len(kept) && total > TrashMaxSize; i++ {
+			remove(kept[i].id)
+			total -= sizes[i]
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// emptyTrash permanently deletes everything in the trash once confirmed.
+func (s *AppState) emptyTrash() {
+	items, err := trashItems()
+	if err != nil {
+		s.showModal("Reading trash failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	if len(items) == 0 {
+		s.updateStatus("Trash is empty")
+		return
+	}
+	s.confirm(fmt.Sprintf("Permanently delete the %d entries in the trash?", len(items)), func(ok bool) {
+		if !ok {
+			return
+		}
+		var errs []error
+		for _, item := range items {
+			if err := removeTrashItem(item.id); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		err := errors.Join(errs...)
+		s.logActivity("empty trash", fmt.Sprintf("%d entries", len(items)), err)
+		if err != nil {
+			s.showModal("Emptying trash failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		} else {
+			s.updateStatus("Trash emptied")
+		}
+		s.refreshList()
+	})
+}
+
+// restoreFromTrash lists the trash, newest first, and puts the chosen entry
+// back where it was deleted from.
+func (s *AppState) restoreFromTrash() {
+	items, err := trashItems()
+	if err != nil {
+		s.showModal("Reading trash failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	if len(items) == 0 {
+		s.updateStatus("Trash is empty")
+		return
+	}
+	slices.Reverse(items)
+	list := tview.NewList().ShowSecondaryText(false)
+	for _, item := range items {
+		label := item.info.Path
+		if label == "" {
+			label = item.name() + " (origin unknown)"
+		}
+		list.AddItem(tview.Escape(label)+"  [gray]"+tview.Escape(item.info.Deleted.Format(TimeLayout))+"[-]", "", 0, func() {
+			_ = s.app.SetRoot(s.layout(), true)
+			s.restoreItem(item)
+		})
+	}
+	list.SetDoneFunc(func() { _ = s.app.SetRoot(s.layout(), true) })
+	list.SetBorder(true).SetTitle(fmt.Sprintf("Restore from trash (%d)", len(items)))
+	_ = s.app.SetRoot(list, true)
+}
+
+// restoreItem restores item to its original path. When something else has
+// taken that path since, or the path was never recorded, it asks where to
+// restore to instead.
+func (s *AppState) restoreItem(item trashItem) {
+	orig := item.info.Path
+	if orig != "" {
+		if _, err := os.Lstat(orig); errors.Is(err, fs.ErrNotExist) {
+			s.finishRestore(item, orig)
+			return
+		}
+	}
+	title := "Restore " + item.name() + " to"
+	initial := filepath.Join(s.currentDir, item.name())
+	if orig != "" {
+		title = orig + " exists; restore to"
+		initial = freeName(orig)
+	}
+	s.askDest(title, initial, func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		dst := s.resolveDest(text, item.name())
+		if _, err := os.Lstat(dst); err == nil {
+			s.showModal("Already exists: "+dst, []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		s.finishRestore(item, dst)
+	})
+}
+
+func (s *AppState) finishRestore(item trashItem, dst string) {
+	err := restoreTrashItem(item.id, dst)
+	s.logActivity("restore", dst, err)
+	if err != nil {
+		s.showModal("Restore failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	s.updateStatus("Restored " + dst)
+	if filepath.Dir(dst) == s.currentDir {
+		s.pendingSelect = filepath.Base(dst)
+	}
+	s.refreshList()
+}
+
+// confirmDelete reports whether deleting paths should be confirmed under
+// the ConfirmDelete policy.
+func confirmDelete(paths []string) bool {
+	many := len(paths) > ConfirmDeleteMax
+	hasDir := func() bool {
+		for _, path := range paths {
+			// a symlink to a directory only loses the link
+			if info, err := os.Lstat(path); err == nil && info.IsDir() {
+				return true
+			}
+		}
+		return false
+	}
+	switch ConfirmDelete {
+	case "never":
+		return false
+	case "dirs":
+		return hasDir()
+	case "many":
+		return many
+	case "dirs-or-many":
+		return many || hasDir()
+	}
+	return true
+}
+
+// renameSelection renames the entry under the cursor. The prompt title
+// shows the resolved target as it is typed, since a name with separators
+// moves the entry elsewhere. Leaving the current directory or replacing an
+// existing file needs an extra confirmation.
+func (s *AppState) renameSelection() {
+	old := s.selectedPath()
+	if old == "" {
+		return
+	}
+	name := filepath.Base(old)
+	initial := name
+	input := tview.NewInputField().SetLabel("New name:").SetText(initial)
+	form := s.askInputField("Rename -> "+old, input, func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		newPath := filepath.Join(s.currentDir, text)
+		if newPath == old {
+			return
+		}
+		rename := func() {
+			err := os.Rename(old, newPath)
+			s.logActivity("rename", old+" -> "+newPath, err)
+			if err != nil {
+				s.showModal("Rename failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+			if s.selected[old] {
+				delete(s.selected, old)
+				s.selected[newPath] = true
+			}
+			if tag, ok := s.tags[old]; ok {
+				delete(s.tags, old)
+				s.tags[newPath] = tag
+				s.saveTags()
+			}
+			s.lastOp = &undoOp{kind: "rename", renames: []renamePair{{from: old, to: newPath}}}
+			s.updateStatus("Renamed to: " + newPath)
+			s.refreshList()
+		}
+		checkExisting := func() {
+			newInfo, err := os.Lstat(newPath)
+			if err != nil {
+				rename()
+				return
+			}
+			// a case-only rename on a case-insensitive filesystem hits the
+			// same file
+			if oldInfo, err := os.Lstat(old); err == nil && os.SameFile(oldInfo, newInfo) {
+				rename()
+				return
+			}
+			s.confirm(newPath+" already exists. Overwrite it?", func(ok bool) {
+				if ok {
+					rename()
+				}
+			})
+		}
+		if filepath.Dir(newPath) != s.currentDir {
+			s.confirm("This moves "+name+" out of the current directory to "+newPath+". Continue?", func(ok bool) {
+				if ok {
+					checkExisting()
+				}
+			})
+			return
+		}
+		checkExisting()
+	})
+	input.SetChangedFunc(func(text string) {
+		form.SetTitle(tview.Escape("Rename -> " + filepath.Join(s.currentDir, text)))
+	})
+}
+
+// copySelection copies the marked entries into a directory, or the entry
+// under the cursor to a new path when nothing is marked.
+func (s *AppState) copySelection() {
+	if paths := s.markedPaths(); len(paths) > 0 {
+		s.copyMarked(paths)
+		return
+	}
+	path := s.selectedPath()
+	if path == "" {
+		return
+	}
+	name := filepath.Base(path)
+	initial := filepath.Join(s.currentDir, name+".copy")
+	if dir := s.copyDestDir(); dir != "" {
+		initial = dir + string(filepath.Separator)
+	}
+	s.askDest("Copy to", initial, func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		src := filepath.Join(s.currentDir, name)
+		dst := s.resolveDest(text, name)
+		s.checkFreeSpace(src, dst, false, func() {
+			if s.dryRun {
+				s.reportDryRun("Would copy "+name, []string{src + " -> " + dst})
+				return
+			}
+			s.lastCopyDir = filepath.Dir(dst)
+			s.runCopy("Copy "+name+" to "+dst, []string{src}, func(c copyFS) error { return c.copyPath(src, dst) }, func(err error) {
+				s.logActivity("copy", src+" -> "+dst, err)
+				if err != nil {
+					s.showModal("Copy failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+					return
+				}
+				s.updateStatus("Copied to: " + dst)
+				s.refreshList()
+			})
+		})
+	})
+}
+
+// duplicateSelection copies the entry under the cursor beside itself as
+// "name (copy).ext" and selects the copy. Directories are copied whole.
+func (s *AppState) duplicateSelection() {
+	src := s.selectedPath()
+	if src == "" {
+		return
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		s.showModal("Duplicate failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	dst := duplicateName(src, info.IsDir())
+	s.checkFreeSpace(src, dst, false, func() {
+		if s.dryRun {
+			s.reportDryRun("Would duplicate "+filepath.Base(src), []string{src + " -> " + dst})
+			return
+		}
+		s.runCopy("Duplicate "+filepath.Base(src), []string{src}, func(c copyFS) error { return c.copyPath(src, dst) }, func(err error) {
+			s.logActivity("copy", src+" -> "+dst, err)
+			if err != nil {
+				s.showModal("Duplicate failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+			s.updateStatus("Duplicated as " + filepath.Base(dst))
+			s.pendingSelect = filepath.Base(dst)
+			s.refreshList()
+		})
+	})
+}
+
+// duplicateName returns "name (copy).ext" beside path, or "name (copy n).ext"
+// for the lowest n from 2 that is not taken. Directories keep any dot in
+// their name.
+func duplicateName(path string, dir bool) string {
+	base := filepath.Base(path)
+	ext := ""
+	if !dir {
+		ext = filepath.Ext(base)
+	}
+	stem := strings.TrimSuffix(base, ext)
+	name := stem + " (copy)" + ext
+	for n := 2; ; n++ {
+		dup := filepath.Join(filepath.Dir(path), name)
+		if _, err := os.Lstat(dup); err != nil {
+			return dup
+		}
+		name = fmt.Sprintf("%s (copy %d)%s", stem, n, ext)
+	}
+}
+
+// copyMarked asks for a directory and copies paths into it.
+func (s *AppState) copyMarked(paths []string) {
+	title := fmt.Sprintf("Copy %d entries into", len(paths))
+	initial := s.currentDir
+	if dir := s.copyDestDir(); dir != "" {
+		initial = dir
+	}
+	s.askDest(title, initial+string(filepath.Separator), func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		dir := s.resolveDest(text, "")
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			s.showModal("Not a directory: "+dir, []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		s.copyInto(paths, dir)
+	})
+}
+
+// copyDestDir is the directory CopyDest says copies should go into, or ""
+// to copy beside the original: with "suffix", or "last" before any copy.
+func (s *AppState) copyDestDir() string {
+	switch CopyDest {
+	case "suffix":
+		return ""
+	case "last":
+		return s.lastCopyDir
+	}
+	return filepath.Clean(expandHome(CopyDest))
+}
+
+// copyInto copies paths into dir, carrying on past entries that fail. Names
+// already taken at the destination are left alone and reported. Once the
+// copy is queued, dir is remembered as the last copy destination.
+func (s *AppState) copyInto(paths []string, dir string) {
+	if s.dryRun {
+		var plan []string
+		for _, src := range paths {
+			dst := filepath.Join(dir, filepath.Base(src))
+			if _, err := os.Lstat(dst); err == nil {
+				plan = append(plan, src+": skipped, "+dst+" exists")
+			} else {
+				plan = append(plan, src+" -> "+dst)
+			}
+		}
+		s.reportDryRun(fmt.Sprintf("Would copy %d entries", len(paths)), plan)
+		return
+	}
+	s.lastCopyDir = dir
+	errs := make([]error, len(paths))
+	s.selected = make(map[string]bool)
+	s.runCopy(fmt.Sprintf("Copy %d entries into %s", len(paths), dir), paths, func(c copyFS) error {
+		for i, src := range paths {
+			dst := filepath.Join(dir, filepath.Base(src))
+			errs[i] = fs.ErrExist
+			if _, statErr := os.Lstat(dst); statErr != nil {
+				errs[i] = c.copyPath(src, dst)
+			}
+		}
+		return nil
+	}, func(error) {
+		var failures []batchFailure
+		for i, src := range paths {
+			s.logActivity("copy", src+" -> "+filepath.Join(dir, filepath.Base(src)), errs[i])
+			if errs[i] != nil {
+				failures = append(failures, batchFailure{src, errs[i]})
+			}
+		}
+		s.reportBatch(fmt.Sprintf("Copied %d of %d entries", len(paths)-len(failures), len(paths)), failures)
+		s.refreshList()
+	})
+}
+
+// moveSelection moves the marked entries into a directory, or the entry
+// under the cursor to a new path when nothing is marked.
+func (s *AppState) moveSelection() {
+	if paths := s.markedPaths(); len(paths) > 0 {
+		s.moveMarked(paths)
+		return
+	}
+	old := s.selectedPath()
+	if old == "" {
+		return
+	}
+	name := filepath.Base(old)
+	s.askDest("Move to", filepath.Join(s.currentDir, name), func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		dst := s.resolveDest(text, name)
+		s.checkFreeSpace(old, dst, true, func() {
+			if s.dryRun {
+				s.reportDryRun("Would move "+name, []string{old + " -> " + dst})
+				return
+			}
+			s.enqueue("Move "+name+" to "+dst, func(*queuedOp) error { return movePath(old, dst) }, func(err error) {
+				s.logActivity("move", old+" -> "+dst, err)
+				if err != nil {
+					s.showModal("Move failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+					return
+				}
+				s.lastOp = &undoOp{kind: "move", renames: []renamePair{{from: old, to: dst}}}
+				s.updateStatus("Moved to: " + dst)
+				s.refreshList()
+			})
+		})
+	})
+}
+
+// movePath renames src to dst. Only when the rename fails because they are
+// on different filesystems does it fall back to copying src, with its
+// links, modes and modification times as they are, and then removing it.
+// Any other error is returned as is.
+func movePath(src, dst string) error {
+	if isAncestor(src, dst) {
+		return fmt.Errorf("cannot move %s into itself", src)
+	}
+	err := os.Rename(src, dst)
+	if err == nil || !crossDevice(err) {
+		return err
+	}
+	c := osCopyFS
+	c.keepLinks, c.preserve = true, true
+	if err := c.copyPath(src, dst); err != nil {
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// batchFailure is an entry a batch operation could not handle.
+type batchFailure struct {
+	path string
+	err  error
+}
+
+func joinFailures(failures []batchFailure) error {
+	var errs []error
+	for _, f := range failures {
+		errs = append(errs, fmt.Errorf("%s: %w", f.path, f.err))
+	}
+	return errors.Join(errs...)
+}
+
+// reportBatch shows how a batch operation went: just the summary in the
+// status bar when everything worked, otherwise a scrollable list of every
+// failed path and the reason.
+func (s *AppState) reportBatch(summary string, failures []batchFailure) {
+	if len(failures) == 0 {
+		s.updateStatus(summary)
+		return
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "[::b]%s[::-]\n[red]%d failed:[-]\n\n", tview.Escape(summary), len(failures))
+	for _, f := range failures {
+		fmt.Fprintf(&text, "%s\n    %s\n", tview.Escape(f.path), tview.Escape(f.err.Error()))
+	}
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetText(text.String())
+	view.SetBorder(true).SetTitle("Errors - Esc to close")
+	s.updateStatus(summary)
+	s.showPager(view)
+}
+
+// reportDryRun shows what an operation would have done under the dry run
+// toggle, one line per step in plan, and records each step in the activity
+// log.
+func (s *AppState) reportDryRun(summary string, plan []string) {
+	var text strings.Builder
+	fmt.Fprintf(&text, "[::b]%s[::-]\n[gray]Dry run: nothing was changed.[-]\n\n", tview.Escape(summary))
+	for _, line := range plan {
+		s.logActivity("dry run", line, nil)
+		fmt.Fprintf(&text, "%s\n", tview.Escape(line))
+	}
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetText(text.String())
+	view.SetBorder(true).SetTitle("Dry run - Esc to close")
+	s.updateStatus(summary + " (dry run)")
+	s.showPager(view)
+}
+
+// failureLines describes each failure on a line of its own.
+func failureLines(failures []batchFailure) []string {
+	var lines []string
+	for _, f := range failures {
+		lines = append(lines, f.path+": "+f.err.Error())
+	}
+	return lines
+}
+
+// conflictChoice is what a batch move does with an entry whose name is
+// already taken at the destination.
+type conflictChoice int
+
+const (
+	conflictAsk conflictChoice = iota
+	conflictOverwrite
+	conflictSkip
+	conflictKeepBoth // move it under a free "name (n)" instead
+)
+
+type moveSummary struct {
+	moved, skipped, overwritten, renamed int
+	failures                             []batchFailure
+	pairs                                []renamePair
+	dryRun                               bool     // only record each move in plan
+	plan                                 []string // what a dry run would have done
+}
+
+// moveMarked asks for a directory and moves paths into it.
+func (s *AppState) moveMarked(paths []string) {
+	title := fmt.Sprintf("Move %d entries into", len(paths))
+	s.askDest(title, s.currentDir+string(filepath.Separator), func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		dir := s.resolveDest(text, "")
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			s.showModal("Not a directory: "+dir, []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		s.moveInto(paths, dir)
+	})
+}
+
+// moveInto moves paths into dir one by one, asking what to do about each
+// name that already exists there unless an earlier answer was applied to
+// all remaining conflicts.
+func (s *AppState) moveInto(paths []string, dir string) {
+	sum := moveSummary{dryRun: s.dryRun}
+	var jobs []moveJob
+	policy := conflictAsk
+	var step func(i int)
+	step = func(i int) {
+		for ; i < len(paths); i++ {
+			src := paths[i]
+			dst := filepath.Join(dir, filepath.Base(src))
+			if dst == src {
+				sum.skipped++
+				continue
+			}
+			if _, err := os.Lstat(dst); err != nil {
+				jobs = append(jobs, moveJob{src, dst, conflictAsk})
+				continue
+			}
+			if policy != conflictAsk {
+				jobs = append(jobs, moveJob{src, dst, policy})
+				continue
+			}
+			next := i + 1
+			s.askConflict(dst, len(paths)-next, func(choice conflictChoice, all bool) {
+				if choice == conflictAsk {
+					s.runMoves(&sum, jobs, dir, true)
+					return
+				}
+				if all {
+					policy = choice
+				}
+				jobs = append(jobs, moveJob{src, dst, choice})
+				step(next)
+			})
+			return
+		}
+		s.runMoves(&sum, jobs, dir, false)
+	}
+	step(0)
+}
+
+// moveJob is one entry of a batch move, with what to do about its name
+// being taken; conflictAsk means it was free.
+type moveJob struct {
+	src, dst string
+	choice   conflictChoice
+}
+
+// runMoves carries out the moves planned by moveInto: on the spot for a
+// dry run, otherwise as a queued operation that stops between entries
+// when cancelled. As the queue may get to it much later, each destination
+// is looked at again before it is moved to, skipped or replaced.
+func (s *AppState) runMoves(sum *moveSummary, jobs []moveJob, dir string, cancelled bool) {
+	if sum.dryRun || len(jobs) == 0 {
+		for _, j := range jobs {
+			sum.move(j.src, j.dst, j.choice)
+		}
+		s.finishMove(sum, cancelled)
+		return
+	}
+	s.selected = make(map[string]bool)
+	s.enqueue(fmt.Sprintf("Move %d entries into %s", len(jobs), dir), func(op *queuedOp) error {
+		for i, j := range jobs {
+			if err := op.ctx.Err(); err != nil {
+				return err
+			}
+			choice := j.choice
+			_, err := os.Lstat(j.dst)
+			switch taken := err == nil; {
+			case taken && choice == conflictAsk:
+				sum.failures = append(sum.failures, batchFailure{j.src, fmt.Errorf("%s was created after the move was queued: %w", j.dst, fs.ErrExist)})
+				continue
+			case !taken && choice != conflictAsk:
+				// whatever was in the way has gone since
+				choice = conflictAsk
+			}
+			sum.move(j.src, j.dst, choice)
+			op.setProgress(fmt.Sprintf("%d of %d entries", i+1, len(jobs)))
+		}
+		return nil
+	}, func(err error) {
+		s.finishMove(sum, cancelled || err != nil)
+	})
+}
+
+// move carries out one entry of a batch move; conflictAsk means dst is
+// free. An entry being overwritten is renamed aside first and only removed
+// once src has taken its place; if the move fails it is put back.
+func (m *moveSummary) move(src, dst string, choice conflictChoice) {
+	aside := ""
+	switch choice {
+	case conflictSkip:
+		m.skipped++
+		return
+	case conflictKeepBoth:
+		dst = freeName(dst)
+	case conflictOverwrite:
+		if isAncestor(dst, src) {
+			m.failures = append(m.failures, batchFailure{src, errors.New("cannot replace a directory that contains it")})
+			return
+		}
+		if m.dryRun {
+			break
+		}
+		aside = freeName(filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".replaced"))
+		if err := os.Rename(dst, aside); err != nil {
+			m.failures = append(m.failures, batchFailure{src, err})
+			return
+		}
+	}
+	if m.dryRun {
+		line := src + " -> " + dst
+		if choice == conflictOverwrite {
+			line += " (replacing it)"
+		}
+		m.plan = append(m.plan, line)
+	} else {
+		if err := movePath(src, dst); err != nil {
+			if aside != "" {
+				if rerr := os.Rename(aside, dst); rerr != nil {
+					err = fmt.Errorf("%w; what it would have replaced is kept as %s", err, aside)
+				}
+			}
+			m.failures = append(m.failures, batchFailure{src, err})
+			return
+		}
+		if aside != "" {
+			if err := os.RemoveAll(aside); err != nil {
+				m.failures = append(m.failures, batchFailure{aside, fmt.Errorf("replaced by %s but not removed: %w", src, err)})
+			}
+		}
+		m.pairs = append(m.pairs, renamePair{from: src, to: dst})
+	}
+	switch choice {
+	case conflictOverwrite:
+		m.overwritten++
+	case conflictKeepBoth:
+		m.renamed++
+	default:
+		m.moved++
+	}
+}
+
+// finishMove reports how a batch move went and refreshes the list.
+func (s *AppState) finishMove(m *moveSummary, cancelled bool) {
+	if m.dryRun {
+		msg := fmt.Sprintf("Would move %d, skip %d, overwrite %d, rename %d", m.moved, m.skipped, m.overwritten, m.renamed)
+		if cancelled {
+			msg += " (cancelled)"
+		}
+		s.reportDryRun(msg, append(m.plan, failureLines(m.failures)...))
+		return
+	}
+	if len(m.pairs) > 0 {
+		s.lastOp = &undoOp{kind: "move", renames: m.pairs}
+	}
+	msg := fmt.Sprintf("Moved %d, skipped %d, overwritten %d, renamed %d", m.moved, m.skipped, m.overwritten, m.renamed)
+	if cancelled {
+		msg += " (cancelled)"
+	}
+	s.logActivity("batch move", msg, joinFailures(m.failures))
+	s.reportBatch(msg, m.failures)
+	s.refreshList()
+}
+
+// askConflict asks what to do about dst already existing. Cancel reports
+// conflictAsk.
+func (s *AppState) askConflict(dst string, remaining int, done func(choice conflictChoice, all bool)) {
+	all := false
+	form := tview.NewForm()
+	if remaining > 0 {
+		form.AddCheckbox(fmt.Sprintf("Apply to all remaining (%d)", remaining), false, func(checked bool) { all = checked })
+	}
+	answer := func(choice conflictChoice) func() {
+		return func() {
+			_ = s.app.SetRoot(s.layout(), true)
+			done(choice, all)
+		}
+	}
+	form.AddButton("Overwrite", answer(conflictOverwrite))
+	form.AddButton("Skip", answer(conflictSkip))
+	form.AddButton("Keep both", answer(conflictKeepBoth))
+	form.AddButton("Cancel", answer(conflictAsk))
+	form.SetCancelFunc(answer(conflictAsk))
+	form.SetBorder(true).SetTitle(tview.Escape(filepath.Base(dst)) + " already exists")
+	_ = s.app.SetRoot(form, true)
+}
+
+// freeName returns path, or "name (n).ext" beside it for the lowest n that
+// is not taken.
+func freeName(path string) string {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(path); err != nil {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+	}
+}
+
+// askDest prompts for a destination path, pre-filled with initial (an
+// absolute path) shown relative to currentDir when DestRelative is set.
+// Ctrl-T flips the field between the relative and absolute forms.
+func (s *AppState) askDest(title, initial string, done func(text string, ok bool)) {
+	relative := DestRelative
+	input := tview.NewInputField().SetLabel("Destination path:").SetText(s.displayDest(initial, relative))
+	var candidates []string
+	next, completed := 0, ""
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyTab {
+			// repeated Tabs cycle through the candidates for the same text
+			if text := input.GetText(); text != completed || len(candidates) == 0 {
+				candidates, next = s.completePath(text), 0
+			}
+			if len(candidates) == 0 {
+				return nil
+			}
+			completed = candidates[next]
+			next = (next + 1) % len(candidates)
+			input.SetText(completed)
+			if len(candidates) == 1 {
+				// a unique match: the next Tab completes inside it
+				completed = ""
+			}
+			return nil
+		}
+		if event.Key() != tcell.KeyCtrlT {
+			return event
+		}
+		relative = !relative
+		text := input.GetText()
+		dir := ""
+		if strings.HasSuffix(text, string(filepath.Separator)) || strings.HasSuffix(text, "/") {
+			dir = string(filepath.Separator)
+		}
+		input.SetText(s.displayDest(s.resolveDest(text, ""), relative) + dir)
+		return nil
+	})
+	s.askInputField(title+" (Tab: complete, Ctrl-T: relative/absolute)", input, done)
+}
+
+// completePath returns the completions of a partly typed path, keeping what
+// was typed before the last separator as is. Directories end in a
+// separator so completion can carry on inside them.
+func (s *AppState) completePath(text string) []string {
+	cut := strings.LastIndexAny(text, "/"+string(filepath.Separator)) + 1
+	typedDir, prefix := text[:cut], text[cut:]
+	dir := expandHome(typedDir)
+	if dir == "" {
+		dir = "."
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.currentDir, dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(prefix, ".")) {
+			continue
+		}
+		if isDir(filepath.Join(dir, name), e) {
+			name += string(filepath.Separator)
+		}
+		out = append(out, typedDir+name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// isDir reports whether e is a directory, following symlinks.
+func isDir(path string, e fs.DirEntry) bool {
+	if e.IsDir() {
+		return true
+	}
+	if e.Type()&fs.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// expandHome replaces a leading "~" with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// displayDest renders the absolute path abs for a destination prompt.
+func (s *AppState) displayDest(abs string, relative bool) string {
+	if !relative {
+		return abs
+	}
+	rel, err := filepath.Rel(s.currentDir, abs)
+	if err != nil {
+		return abs
+	}
+	if strings.HasSuffix(abs, string(filepath.Separator)) && rel != "." {
+		// still "into that directory"
+		rel += string(filepath.Separator)
+	}
+	return rel
+}
+
+// resolveDest turns destination prompt text into an absolute path.
+// Relative text is taken from currentDir, and a trailing separator means
+// "into that directory", keeping the source name.
+func (s *AppState) resolveDest(text, name string) string {
+	into := strings.HasSuffix(text, string(filepath.Separator)) || strings.HasSuffix(text, "/")
+	dst := expandHome(text)
+	if !filepath.IsAbs(dst) {
+		dst = filepath.Join(s.currentDir, dst)
+	}
+	dst = filepath.Clean(dst)
+	if into && name != "" {
+		dst = filepath.Join(dst, name)
+	}
+	return dst
+}
+
+// Bulk rename and undo
+
+type renamePair struct {
+	from, to string
+}
+
+// undoOp records the last reversible operation. Renames and moves (single
+// or bulk) are reversible by renaming everything back.
+type undoOp struct {
+	kind    string
+	renames []renamePair
+}
+
+// bulkRenameSelection replaces a substring in the names of all marked
+// entries (or the one under the cursor). Every new name is checked before
+// anything is renamed, and a failure part-way rolls the finished renames
+// back.
+func (s *AppState) bulkRenameSelection() {
+	paths := s.markedPaths()
+	if len(paths) == 0 {
+		if path := s.selectedPath(); path != "" {
+			paths = []string{path}
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	find := tview.NewInputField().SetLabel("Find:")
+	replace := tview.NewInputField().SetLabel("Replace with:")
+	title := fmt.Sprintf("Rename %d entries", len(paths))
+	s.askForm(title, []*tview.InputField{find, replace}, func(ok bool) {
+		if !ok || find.GetText() == "" {
+			return
+		}
+		pairs, err := planBulkRename(paths, find.GetText(), replace.GetText())
+		if err != nil {
+			s.showModal("Bulk rename failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		if len(pairs) == 0 {
+			s.updateStatus("No names contain " + find.GetText())
+			return
+		}
+		if s.dryRun {
+			var plan []string
+			for _, p := range pairs {
+				plan = append(plan, p.from+" -> "+filepath.Base(p.to))
+			}
+			s.reportDryRun(fmt.Sprintf("Would rename %d entries", len(pairs)), plan)
+			return
+		}
+		err = renameAll(pairs)
+		s.logActivity("bulk rename", fmt.Sprintf("%d entries (%q -> %q)", len(pairs), find.GetText(), replace.GetText()), err)
+		if err != nil {
+			s.showModal("Bulk rename failed, nothing was changed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		s.lastOp = &undoOp{kind: "bulk rename", renames: pairs}
+		s.selected = make(map[string]bool)
+		s.updateStatus(fmt.Sprintf("Renamed %d entries", len(pairs)))
+		s.refreshList()
+	})
+}
+
+// planBulkRename works out the renames for replacing find with replace in
+// the base names of paths, refusing targets that already exist or collide.
+func planBulkRename(paths []string, find, replace string) ([]renamePair, error) {
+	var pairs []renamePair
+	targets := make(map[string]bool)
+	for _, p := range paths {
+		name := filepath.Base(p)
+		newName := strings.ReplaceAll(name, find, replace)
+		if newName == name {
+			continue
+		}
+		if newName == "" || strings.ContainsRune(newName, filepath.Separator) {
+			return nil, fmt.Errorf("%s would become %q", name, newName)
+		}
+		to := filepath.Join(filepath.Dir(p), newName)
+		if targets[to] {
+			return nil, fmt.Errorf("more than one entry would be named %s", newName)
+		}
+		if _, err := os.Lstat(to); err == nil {
+			return nil, fmt.Errorf("%s already exists", newName)
+		}
+		targets[to] = true
+		pairs = append(pairs, renamePair{from: p, to: to})
+	}
+	return pairs, nil
+}
+
+// renameAll renames each pair in order. If one fails, the renames already
+// done are reversed so nothing is left half-applied.
+func renameAll(pairs []renamePair) error {
+	for i, p := range pairs {
+		if err := os.Rename(p.from, p.to); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				_ = os.Rename(pairs[j].to, pairs[j].from)
+			}
+			return fmt.Errorf("%s: %w", filepath.Base(p.from), err)
+		}
+	}
+	return nil
+}
+
+// undo reverses the last recorded operation. Before touching anything it
+// checks that every renamed entry is still where it was put and that its
+// old name is free, so later changes are never clobbered.
+func (s *AppState) undo() {
+	op := s.lastOp
+	if op == nil {
+		s.updateStatus("Nothing to undo")
+		return
+	}
+	var back []renamePair
+	for i := len(op.renames) - 1; i >= 0; i-- {
+		p := op.renames[i]
+		if _, err := os.Lstat(p.to); err != nil {
+			s.showModal("Cannot undo "+op.kind+": "+p.to+" has changed since", []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		if _, err := os.Lstat(p.from); err == nil {
+			s.showModal("Cannot undo "+op.kind+": "+p.from+" exists again", []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		back = append(back, renamePair{from: p.to, to: p.from})
+	}
+	s.confirm(fmt.Sprintf("Undo %s of %d entries?", op.kind, len(back)), func(ok bool) {
+		if !ok {
+			return
+		}
+		err := renameAll(back)
+		s.logActivity("undo", fmt.Sprintf("%s of %d entries", op.kind, len(back)), err)
+		if err != nil {
+			s.showModal("Undo failed, nothing was changed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		s.lastOp = nil
+		s.updateStatus("Undid " + op.kind)
+		s.refreshList()
+	})
+}
+
+// checkFreeSpace estimates the size of src and warns before running op if it
+// will not fit on the filesystem that would hold dst. Moves within one
+// filesystem need no extra space and run straight away. The estimate runs in
+// the background and can be cancelled from the modal shown meanwhile.
+func (s *AppState) checkFreeSpace(src, dst string, move bool, op func()) {
+	target := existingAncestor(dst)
+	if move && sameFilesystem(src, target) {
+		op()
+		return
+	}
+	free, err := freeSpace(target)
+	if err != nil {
+		// can't tell; don't stand in the way
+		op()
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	modal := tview.NewModal().SetText(tview.Escape("Estimating size of " + filepath.Base(src) + "...")).AddButtons([]string{"Cancel"}).SetDoneFunc(func(_ int, _ string) {
+		cancel()
+		_ = s.app.SetRoot(s.layout(), true)
+		s.updateStatus("Cancelled")
+	})
+	_ = s.app.SetRoot(modal, true)
+
+	go func() {
+		progress := make(chan int64)
+		go func() {
+			for n := range progress {
+				s.app.QueueUpdateDraw(func() {
+					if ctx.Err() == nil {
+						modal.SetText(tview.Escape(fmt.Sprintf("Estimating size of %s... %s so far", filepath.Base(src), humanSize(n))))
+					}
+				})
+			}
+		}()
+		size, err := dirSize(ctx, src, progress)
+		s.app.QueueUpdateDraw(func() {
+			if ctx.Err() != nil {
+				return
+			}
+			cancel()
+			_ = s.app.SetRoot(s.layout(), true)
+			if err != nil || uint64(size) <= free {
+				op()
+				return
+			}
+			msg := fmt.Sprintf("%s needs %s but only %s is free at %s. Continue anyway?",
+				filepath.Base(src), humanSize(size), humanSize(int64(free)), target)
+			s.confirm(msg, func(ok bool) {
+				if ok {
+					op()
+				}
+			})
+		})
+	}()
+}
+
+// copyRateSmoothing weighs the latest rate sample against the running
+// average shown while copying; lower is steadier.
+const copyRateSmoothing = 0.3
+
+// runCopy queues work as an operation called desc (see opQueue) and
+// calls done with its error on the UI goroutine once it has run. How much
+// of srcs has been copied, the rate and the time left show in the
+// operations panel; work must copy through the copyFS it is given for
+// that, and for cancelling to stop it.
+func (s *AppState) runCopy(desc string, srcs []string, work func(c copyFS) error, done func(err error)) {
+	keepLinks := !s.followLinks
+	s.enqueue(desc, func(op *queuedOp) error {
+		var total int64
+		for _, src := range srcs {
+			// usually cached by the free space check
+			if n, err := dirSize(op.ctx, src, nil); err == nil {
+				total += n
+			}
+		}
+		stop := op.trackCopy(total)
+		defer stop()
+		c := osCopyFS
+		c.copied, c.ctx, c.keepLinks = &op.copied, op.ctx, keepLinks
+		return work(c)
+	}, done)
+}
+
+// copyProgressText describes a copy n bytes of total in. The rate and time
+// left are only shown once a second has passed, as tiny copies finish
+// before they mean anything.
+func copyProgressText(n, total int64, elapsed time.Duration, rate float64) string {
+	text := "Copying... " + humanSize(n)
+	if total > 0 {
+		text = fmt.Sprintf("Copying... %d%%\n%s of %s", min(100, n*100/total), humanSize(n), humanSize(total))
+	}
+	if elapsed < time.Second || rate <= 0 {
+		return text
+	}
+	text += fmt.Sprintf("\n%s/s", humanSize(int64(rate)))
+	if total > n {
+		left := time.Duration(float64(total-n) / rate * float64(time.Second))
+		text += ", about " + left.Round(time.Second).String() + " left"
+	}
+	return text
+}
+
+// dirSizeProgressEvery is how often dirSize reports its running total.
+const dirSizeProgressEvery = 200 * time.Millisecond
+
+// dirSize returns the total size of the regular files under path (or of path
+// itself if it is a file). Unreadable entries are skipped. The walk stops as
+// soon as ctx is cancelled. Directory totals are remembered by path and
+// modtime (see cachedDirSize), so every feature that needs a tree's size
+// shares one walk. If progress is not nil, the running total is sent on it
+// now and then while a reader is waiting, and it is closed on return.
+func dirSize(ctx context.Context, path string, progress chan<- int64) (int64, error) {
+	if progress != nil {
+		defer close(progress)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		if info.Mode().IsRegular() {
+			return info.Size(), nil
+		}
+		return 0, nil
+	}
+	if size, ok := cachedDirSize(path, info); ok {
+		return size, nil
+	}
+	var total int64
+	lastReport := time.Now()
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if p == path {
+				return err
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		if progress != nil && time.Since(lastReport) > dirSizeProgressEvery {
+			// never wait on a slow reader
+			select {
+			case progress <- total:
+			default:
+			}
+			lastReport = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		return total, err
+	}
+	storeDirSize(path, info, total)
+	return total, nil
+}
+
+// existingAncestor walks up from path until it finds something that exists,
+// which is where a not-yet-created destination would land.
+func existingAncestor(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	for {
+		if _, err := os.Stat(abs); err == nil {
+			return abs
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return abs
+		}
+		abs = parent
+	}
+}
+
+// checkCopyTarget refuses a destination that is the source itself or lies
+// inside it; copying a directory into its own subtree would never finish.
+func checkCopyTarget(src, dst string) error {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return err
+	}
+	if absSrc == absDst {
+		return fmt.Errorf("cannot copy %s onto itself", absSrc)
+	}
+	rel, err := filepath.Rel(absSrc, absDst)
+	if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("cannot copy %s into its own subdirectory %s", absSrc, absDst)
+	}
+	return nil
+}
+
+// errPrivilegeNotHeld is ERROR_PRIVILEGE_NOT_HELD, which Windows returns
+// when creating a symlink without Developer Mode or admin rights.
+const errPrivilegeNotHeld = syscall.Errno(1314)
+
+func (s *AppState) symlinkSelection() {
+	target := s.selectedPath()
+	if target == "" {
+		return
+	}
+	name := filepath.Base(target)
+	s.askInput("Symlink", "Link path:", name+".link", func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		link := text
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(s.currentDir, link)
+		}
+		err := os.Symlink(target, link)
+		s.logActivity("symlink", link+" -> "+target, err)
+		switch {
+		case err == nil:
+		case errors.Is(err, fs.ErrExist):
+			s.showModal("Symlink failed: "+link+" already exists", []string{"OK"}, func(_ int, _ string) {})
+			return
+		case runtime.GOOS == "windows" && errors.Is(err, errPrivilegeNotHeld):
+			s.showModal("Symlink failed: Windows only allows symlinks with Developer Mode enabled or from an elevated prompt.", []string{"OK"}, func(_ int, _ string) {})
+			return
+		default:
+			s.showModal("Symlink failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		s.updateStatus("Linked " + link + " -> " + target)
+		s.refreshList()
+	})
+}
+
+// hardlinkSelection links a new name in the current directory to the
+// selected file. Directories and cross-filesystem links are rejected up
+// front since the OS would refuse them anyway with a less helpful error.
+func (s *AppState) hardlinkSelection() {
+	target := s.selectedPath()
+	if target == "" {
+		return
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		s.showModal("Hardlink failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	if info.IsDir() {
+		s.showModal("Hardlink failed: directories cannot be hard linked", []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	name := filepath.Base(target)
+	s.askInput("Hardlink", "Link name:", name+".link", func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
+			return
+		}
+		link := text
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(s.currentDir, link)
+		}
+		if !sameFilesystem(target, existingAncestor(filepath.Dir(link))) {
+			s.showModal("Hardlink failed: "+link+" is on a different filesystem", []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		err := os.Link(target, link)
+		s.logActivity("hardlink", link+" -> "+target, err)
+		if err != nil {
+			if errors.Is(err, fs.ErrExist) {
+				s.showModal("Hardlink failed: "+link+" already exists", []string{"OK"}, func(_ int, _ string) {})
+			} else {
+				s.showModal("Hardlink failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			}
+			return
+		}
+		s.updateStatus("Hard linked " + link + " to " + name)
+		s.refreshList()
+	})
+}
+
+// copyFS is the filesystem the copy functions work on. osCopyFS is the
+// real one; the fields can be swapped to simulate failures part-way
+// through a copy.
+type copyFS struct {
+	stat      func(name string) (fs.FileInfo, error)
+	readDir   func(name string) ([]fs.DirEntry, error)
+	open      func(name string) (io.ReadCloser, error)
+	create    func(name string) (io.WriteCloser, error)
+	mkdirAll  func(name string, perm fs.FileMode) error
+	removeAll func(name string) error
+	lstat     func(name string) (fs.FileInfo, error)
+	readlink  func(name string) (string, error)
+	symlink   func(oldname, newname string) error
+	chmod     func(name string, mode fs.FileMode) error
+	chtimes   func(name string, atime, mtime time.Time) error
+	copied    *atomic.Int64   // if set, counts the bytes copied so far
+	ctx       context.Context // if set, stops the copy once cancelled
+	keepLinks bool            // recreate symlinks instead of copying their targets
+	preserve  bool            // give copies the mode and modification time of the originals
+}
+
+// cancelled returns the error of a cancelled ctx, or nil.
+func (c copyFS) cancelled() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+var osCopyFS = copyFS{
+	stat:      os.Stat,
+	readDir:   os.ReadDir,
+	open:      func(name string) (io.ReadCloser, error) { return os.Open(name) },
+	create:    func(name string) (io.WriteCloser, error) { return os.Create(name) },
+	mkdirAll:  os.MkdirAll,
+	removeAll: os.RemoveAll,
+	lstat:     os.Lstat,
+	readlink:  os.Readlink,
+	symlink:   os.Symlink,
+	chmod:     os.Chmod,
+	chtimes:   os.Chtimes,
+}
+
+func copyPath(src, dst string) error {
+	return osCopyFS.copyPath(src, dst)
+}
+
+func (c copyFS) copyPath(src, dst string) error {
+	if err := c.cancelled(); err != nil {
+		return err
+	}
+	if err := checkCopyTarget(src, dst); err != nil {
+		return err
+	}
+	stat := c.stat
+	if c.keepLinks {
+		stat = c.lstat
+	}
+	info, err := stat(src)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		return c.copyLink(src, dst)
+	}
+	if info.IsDir() {
+		// copy directory recursively
+		return c.copyDir(src, dst)
+	}
+	return c.copyFile(src, dst)
+}
+
+// copyFile copies one file. A copy that fails after dst was created
+// removes the partial file.
+func (c copyFS) copyFile(src, dst string) (err error) {
+	in, err := c.open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var r io.Reader = in
+	if c.copied != nil || c.ctx != nil {
+		r = countingReader{in, c}
+	}
+	out, err := c.create(dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = c.removeAll(dst)
+		}
+	}()
+	if CopyBufferSize > 0 {
+		// hide the file types so io.CopyBuffer really uses our buffer
+		buf := make([]byte, CopyBufferSize)
+		_, err = io.CopyBuffer(struct{ io.Writer }{out}, struct{ io.Reader }{r}, buf)
+	} else {
+		_, err = io.Copy(out, r)
+	}
+	if err != nil {
+		return err
+	}
+	if f, ok := out.(interface{ Sync() error }); ok {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	if c.preserve {
+		return c.preserveAttrs(src, dst)
+	}
+	return nil
+}
+
+// preserveAttrs gives dst the permissions and modification time of src.
+func (c copyFS) preserveAttrs(src, dst string) error {
+	info, err := c.lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := c.chmod(dst, info.Mode()&fs.ModePerm); err != nil {
+		return err
+	}
+	return c.chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// countingReader adds the bytes read through it to the copyFS's count, and
+// fails once its copy is cancelled.
+type countingReader struct {
+	r  io.Reader
+	fs copyFS
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	if err := c.fs.cancelled(); err != nil {
+		return 0, err
+	}
+	n, err := c.r.Read(p)
+	if c.fs.copied != nil {
+		c.fs.copied.Add(int64(n))
+	}
+	return n, err
+}
+
+type copyJob struct {
+	src, dst string
+	link     bool // recreate the symlink at src rather than copy its target
+}
+
+// copyLink makes dst a symlink with the same target as the one at src.
+func (c copyFS) copyLink(src, dst string) error {
+	target, err := c.readlink(src)
+	if err != nil {
+		return err
+	}
+	return c.symlink(target, dst)
+}
+
+// copyDir recreates the directory tree under dst first, so every file has
+// its parent in place, and then copies the files with up to CopyWorkers at
+// a time. It keeps going past failed files and returns all their errors.
+// If anything fails, including the tree not being readable or the copy
+// being cancelled, a dst that didn't exist before is removed again, so no
+// half copy is left behind.
+func (c copyFS) copyDir(src, dst string) (err error) {
+	if err := checkCopyTarget(src, dst); err != nil {
+		return err
+	}
+	if _, statErr := c.stat(dst); errors.Is(statErr, fs.ErrNotExist) {
+		defer func() {
+			if err != nil {
+				_ = c.removeAll(dst)
+			}
+		}()
+	}
+	var jobs []copyJob
+	dirs, err := c.planCopyDir(src, dst, &jobs)
+	if err != nil {
+		return err
+	}
+	if err := c.copyFiles(jobs); err != nil || !c.preserve {
+		return err
+	}
+	// deepest first: setting a directory's attributes must come after
+	// everything inside it has been written
+	for _, dir := range slices.Backward(dirs) {
+		if err := c.preserveAttrs(dir.src, dir.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planCopyDir creates the directories of the tree at src under dst and
+// lists the files to copy. It returns the directories, parents before
+// children. It works through a queue rather than recursing, so however
+// deep the tree is only the queue grows.
+func (c copyFS) planCopyDir(src, dst string, jobs *[]copyJob) ([]copyJob, error) {
+	var dirs []copyJob
+	queue := []copyJob{{src: src, dst: dst}}
+	// directories reached so far through links, plus the top one
+	var followed []fs.FileInfo
+	if info, err := c.stat(src); err == nil {
+		followed = append(followed, info)
+	}
+	for len(queue) > 0 {
+		if err := c.cancelled(); err != nil {
+			return nil, err
+		}
+		dir := queue[0]
+		queue = queue[1:]
+		entries, err := c.readDir(dir.src)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.mkdirAll(dir.dst, 0755); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, dir)
+		for _, e := range entries {
+			job := copyJob{src: filepath.Join(dir.src, e.Name()), dst: filepath.Join(dir.dst, e.Name())}
+			if e.IsDir() {
+				queue = append(queue, job)
+				continue
+			}
+			if e.Type()&fs.ModeSymlink != 0 {
+				job.link = c.keepLinks
+				if info, err := c.stat(job.src); !job.link && err == nil && info.IsDir() {
+					seen := slices.ContainsFunc(followed, func(f fs.FileInfo) bool { return os.SameFile(f, info) })
+					if !seen {
+						followed = append(followed, info)
+						queue = append(queue, job)
+						continue
+					}
+					// a second way into the same directory, possibly a
+					// loop back up the tree, is kept as a link
+					job.link = true
+				}
+			}
+			*jobs = append(*jobs, job)
+		}
+	}
+	return dirs, nil
+}
+
+func (c copyFS) copyFiles(jobs []copyJob) error {
+	workers := CopyWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	queue := make(chan copyJob)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if c.cancelled() != nil {
+					continue
+				}
+				run := c.copyFile
+				if job.link {
+					run = c.copyLink
+				}
+				if err := run(job.src, job.dst); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+	if err := c.cancelled(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Operations
+
+// opState is where a queued operation is in its life.
+type opState int
+
+const (
+	opQueued opState = iota
+	opRunning
+	opDone
+	opFailed
+	opCancelled
+)
+
+func (st opState) String() string {
+	return [...]string{"queued", "running", "done", "failed", "cancelled"}[st]
+}
+
+// opHistory is how many finished operations the panel keeps listing.
+const opHistory = 20
+
+// queuedOp is a copy, move or delete run in the background by the
+// operation queue. run does the work off the UI goroutine and should stop
+// early once ctx is cancelled; done then gets its error on the UI
+// goroutine.
+type queuedOp struct {
+	desc   string
+	run    func(op *queuedOp) error
+	done   func(err error)
+	ctx    context.Context
+	cancel context.CancelFunc
+	copied atomic.Int64 // bytes copied, for operations that copy
+
+	mu       sync.Mutex // guards the fields below
+	state    opState
+	err      error
+	progress string
+}
+
+func (op *queuedOp) setProgress(text string) {
+	op.mu.Lock()
+	op.progress = text
+	op.mu.Unlock()
+}
+
+// trackCopy keeps the progress text up to date with how much of total
+// bytes has been copied, until the returned func is called.
+func (op *queuedOp) trackCopy(total int64) (stop func()) {
+	finished := make(chan struct{})
+	go func() {
+		start, last, lastN := time.Now(), time.Now(), int64(0)
+		var rate float64
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-finished:
+				return
+			case now := <-ticker.C:
+				n := op.copied.Load()
+				sample := float64(n-lastN) / now.Sub(last).Seconds()
+				if rate == 0 {
+					rate = sample
+				} else {
+					rate = copyRateSmoothing*sample + (1-copyRateSmoothing)*rate
+				}
+				last, lastN = now, n
+				text := copyProgressText(n, total, now.Sub(start), rate)
+				op.setProgress(strings.ReplaceAll(strings.TrimPrefix(text, "Copying... "), "\n", ", "))
+			}
+		}
+	}()
+	return func() { close(finished) }
+}
+
+// label is the operation's line in the operations panel.
+func (op *queuedOp) label() string {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	color := map[opState]string{opQueued: "gray", opRunning: "yellow", opDone: "green", opFailed: "red", opCancelled: "gray"}[op.state]
+	label := fmt.Sprintf("[%s]%-9s[-] %s", color, op.state, tview.Escape(op.desc))
+	switch {
+	case op.state == opRunning && op.progress != "":
+		label += "  [gray]" + tview.Escape(op.progress) + "[-]"
+	case op.state == opFailed:
+		label += "  [red]" + tview.Escape(strings.ReplaceAll(op.err.Error(), "\n", "; ")) + "[-]"
+	}
+	return label
+}
+
+// opQueue runs queued operations one at a time, oldest first, on a single
+// worker goroutine, so large copies and moves no longer hold up browsing.
+type opQueue struct {
+	mu   sync.Mutex
+	ops  []*queuedOp // queued, running and the latest finished, oldest first
+	wake chan struct{}
+}
+
+func newOpQueue() *opQueue {
+	return &opQueue{wake: make(chan struct{}, 1)}
+}
+
+// next marks the oldest queued operation running and returns it, or nil
+// when there is none.
+func (q *opQueue) next() *queuedOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, op := range q.ops {
+		op.mu.Lock()
+		queued := op.state == opQueued
+		if queued {
+			op.state = opRunning
+		}
+		op.mu.Unlock()
+		if queued {
+			return op
+		}
+	}
+	return nil
+}
+
+// counts returns how many operations are running and waiting.
+func (q *opQueue) counts() (running, queued int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, op := range q.ops {
+		op.mu.Lock()
+		switch op.state {
+		case opRunning:
+			running++
+		case opQueued:
+			queued++
+		}
+		op.mu.Unlock()
+	}
+	return running, queued
+}
+
+// list returns the operations, oldest first, dropping finished ones beyond
+// the last opHistory.
+func (q *opQueue) list() []*queuedOp {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	finished := 0
+	for i := len(q.ops) - 1; i >= 0; i-- {
+		op := q.ops[i]
+		op.mu.Lock()
+		old := op.state >= opDone
+		op.mu.Unlock()
+		if old {
+			finished++
+			if finished > opHistory {
+				q.ops = append(q.ops[:i], q.ops[i+1:]...)
+			}
+		}
+	}
+	return slices.Clone(q.ops)
+}
+
+// enqueue adds an operation called desc to the queue. It must be called on
+// the UI goroutine.
+func (s *AppState) enqueue(desc string, run func(op *queuedOp) error, done func(err error)) {
+	op := &queuedOp{desc: desc, run: run, done: done}
+	op.ctx, op.cancel = context.WithCancel(context.Background())
+	s.ops.mu.Lock()
+	s.ops.ops = append(s.ops.ops, op)
+	s.ops.mu.Unlock()
+	select {
+	case s.ops.wake <- struct{}{}:
+	default:
+	}
+	s.updateStatus("Queued: " + desc + " (" + keyName(KeyOps) + " shows progress)")
+}
+
+// runOps is the queue's worker.
+func (s *AppState) runOps() {
+	for range s.ops.wake {
+		for op := s.ops.next(); op != nil; op = s.ops.next() {
+			s.app.QueueUpdateDraw(s.renderStatus)
+			err := op.run(op)
+			op.mu.Lock()
+			switch {
+			case err == nil:
+				op.state = opDone
+			case op.ctx.Err() != nil:
+				op.state = opCancelled
+			default:
+				op.state = opFailed
+			}
+			op.err = err
+			op.mu.Unlock()
+			op.cancel()
+			s.app.QueueUpdateDraw(func() {
+				op.done(err)
+				s.renderStatus()
+			})
+		}
+	}
+}
+
+// cancelOp stops op if it is running, or drops it from the queue if it has
+// not started. It must be called on the UI goroutine.
+func (s *AppState) cancelOp(op *queuedOp) {
+	op.mu.Lock()
+	state := op.state
+	if state == opQueued {
+		op.state, op.err = opCancelled, context.Canceled
+	}
+	op.mu.Unlock()
+	switch state {
+	case opQueued:
+		op.cancel()
+		op.done(context.Canceled)
+	case opRunning:
+		op.cancel()
+		s.updateStatus("Cancelling: " + op.desc)
+	}
+	s.renderStatus()
+}
+
+// showOps shows the operations panel: what is queued, running and recently
+// finished, refreshed while it is open. Enter or x cancels the highlighted
+// operation; Esc closes the panel and the operations carry on.
+func (s *AppState) showOps() {
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle("Operations - Enter/x cancel, Esc close")
+	var shown []*queuedOp
+	update := func() {
+		cur := list.GetCurrentItem()
+		shown = s.ops.list()
+		list.Clear()
+		for _, op := range shown {
+			list.AddItem(op.label(), "", 0, nil)
+		}
+		if len(shown) == 0 {
+			list.AddItem("[gray]No operations yet[-]", "", 0, nil)
+		}
+		list.SetCurrentItem(cur)
+	}
+	stop := make(chan struct{})
+	closePanel := func() {
+		close(stop)
+		_ = s.app.SetRoot(s.layout(), true)
+	}
+	cancelCurrent := func() {
+		if i := list.GetCurrentItem(); i >= 0 && i < len(shown) {
+			s.cancelOp(shown[i])
+			update()
+		}
+	}
+	list.SetSelectedFunc(func(int, string, string, rune) { cancelCurrent() })
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyEscape, event.Key() == tcell.KeyRune && event.Rune() == KeyOps:
+			closePanel()
+			return nil
+		case event.Key() == tcell.KeyRune && event.Rune() == 'x':
+			cancelCurrent()
+			return nil
+		}
+		return event
+	})
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				s.app.QueueUpdateDraw(func() {
+					select {
+					case <-stop:
+					default:
+						update()
+					}
+				})
+			}
+		}
+	}()
+	update()
+	_ = s.app.SetRoot(list, true)
+}
+
+// Tabs
+
+// tab is a directory view kept aside while another tab is shown. Only the
+// shown tab lives in AppState's fields.
+type tab struct {
+	dir       string
+	selected  string // name of the entry under the cursor
+	index     int    // where the cursor was, for when selected is gone
+	offset    int    // first list row shown
+	filter    nameFilter
+	showType  showType
+	tagFilter string
+}
+
+// saveTab records the shown view in its tab.
+func (s *AppState) saveTab() {
+	t := &s.tabs[s.tabIndex]
+	t.dir = s.currentDir
+	t.selected = ""
+	if path := s.selectedPath(); path != "" {
+		t.selected = filepath.Base(path)
+	}
+	t.index = s.filesList.GetCurrentItem()
+	t.offset, _ = s.filesList.GetOffset()
+	t.filter, t.showType, t.tagFilter = s.filter, s.showType, s.tagFilter
+}
+
+// showTab switches the view to tab i. The current tab must have been saved
+// first.
+func (s *AppState) showTab(i int) {
+	s.tabIndex = i
+	t := s.tabs[i]
+	// a directory removed while its tab was in the background falls back
+	// to what is left of it
+	if dir := existingAncestor(t.dir); dir != t.dir {
+		t = tab{dir: dir}
+	}
+	s.currentDir = t.dir
+	s.filter, s.showType, s.tagFilter = t.filter, t.showType, t.tagFilter
+	// the entry keeps the cursor if it is still there, otherwise its old
+	// row does, clamped to what is left
+	s.pendingSelect = t.selected
+	s.refreshListThen(t.index, func() {
+		// the list moves the offset again if the cursor is not in view
+		s.filesList.SetOffset(min(t.offset, s.filesList.GetCurrentItem()), 0)
+		s.loadPreviewForSelection()
+	})
+	s.updateStatus(fmt.Sprintf("Tab %d of %d", i+1, len(s.tabs)))
+}
+
+// openParentTab opens the parent of the current directory in a new tab
+// with the current directory selected, leaving this tab as it is.
+func (s *AppState) openParentTab() {
+	parent := filepath.Dir(s.currentDir)
+	if parent == s.currentDir {
+		s.updateStatus("Already at the filesystem root")
+		return
+	}
+	s.saveTab()
+	s.tabs = append(s.tabs, tab{dir: parent, selected: filepath.Base(s.currentDir)})
+	s.showTab(len(s.tabs) - 1)
+	s.updateStatus("Opened " + parent + " in a new tab")
+}
+
+// cycleTab shows the tab delta places away, wrapping around.
+func (s *AppState) cycleTab(delta int) {
+	if len(s.tabs) < 2 {
+		s.updateStatus("Only one tab open")
+		return
+	}
+	s.saveTab()
+	s.showTab((s.tabIndex + delta + len(s.tabs)) % len(s.tabs))
+}
+
+func (s *AppState) closeTab() {
+	if len(s.tabs) < 2 {
+		s.updateStatus("Can't close the last tab")
+		return
+	}
+	s.tabs = slices.Delete(s.tabs, s.tabIndex, s.tabIndex+1)
+	s.showTab(min(s.tabIndex, len(s.tabs)-1))
+}
+
+// Bookmarks
+
+// bookmark is a pinned directory, or a file to be selected in its
+// directory when jumped to.
+type bookmark struct {
+	path string
+	file bool
+}
+
+func (s *AppState) toggleBookmark() {
+	s.toggleBookmarkFor(bookmark{path: s.currentDir})
+}
+
+// toggleFileBookmark pins the entry under the cursor rather than the
+// directory.
+func (s *AppState) toggleFileBookmark() {
+	path := s.selectedPath()
+	if path == "" {
+		return
+	}
+	s.toggleBookmarkFor(bookmark{path: path, file: true})
+}
+
+func (s *AppState) toggleBookmarkFor(b bookmark) {
+	for i, have := range s.bookmarks {
+		if have == b {
+			// remove
+			s.bookmarks = append(s.bookmarks[:i], s.bookmarks[i+1:]...)
+			s.updateStatus("Removed bookmark")
+			return
+		}
+	}
+	s.bookmarks = append(s.bookmarks, b)
+	s.updateStatus("Bookmarked " + b.path)
+}
+
+// isBookmarked reports whether dir has a directory bookmark.
+func (s *AppState) isBookmarked(dir string) bool {
+	for _, b := range s.bookmarks {
+		if !b.file && b.path == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// listBookmarks shows the bookmarks under an input that narrows them to the
+// paths containing what is typed. Esc clears the input, or closes the list
+// when it is empty.
+func (s *AppState) listBookmarks() {
+	if len(s.bookmarks) == 0 {
+		s.showModal("No bookmarks set", []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	input := tview.NewInputField().SetLabel("Filter: ")
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle("Bookmarks")
+	var shown []bookmark
+	update := func() {
+		term := strings.ToLower(input.GetText())
+		shown = shown[:0]
+		list.Clear()
+		for i, b := range s.bookmarks {
+			if !strings.Contains(strings.ToLower(b.path), term) {
+				continue
+			}
+			shown = append(shown, b)
+			label := tview.Escape("[DIR]  " + b.path)
+			if b.file {
+				label = tview.Escape("[FILE] " + b.path)
+			}
+			slot := "  "
+			if i < bookmarkSlots {
+				slot = strconv.Itoa(i+1) + " "
+			}
+			list.AddItem("[gray]"+slot+"[-]"+label, "", 0, nil)
+		}
+		list.SetTitle(fmt.Sprintf("Bookmarks (%d of %d)", len(shown), len(s.bookmarks)))
+	}
+	input.SetChangedFunc(func(string) { update() })
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			list.InputHandler()(event, func(p tview.Primitive) {})
+			return nil
+		case tcell.KeyEscape:
+			if input.GetText() != "" {
+				input.SetText("")
+				return nil
+			}
+		}
+		return event
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			i := list.GetCurrentItem()
+			if i < 0 || i >= len(shown) {
+				return
+			}
+			_ = s.app.SetRoot(s.layout(), true)
+			s.jumpToBookmark(shown[i])
+		case tcell.KeyEscape:
+			_ = s.app.SetRoot(s.layout(), true)
+		}
+	})
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow)
+	layout.AddItem(input, 1, 0, true)
+	layout.AddItem(list, 0, 1, false)
+	update()
+	_ = s.app.SetRoot(layout, true)
+}
+
+// bookmarkSlots is how many bookmarks, from the top of the list, can be
+// jumped to with KeyBookSlot and a digit.
+const bookmarkSlots = 9
+
+// startSlotJump waits for the digit of the bookmark to jump to; see
+// jumpToSlot.
+func (s *AppState) startSlotJump() {
+	if len(s.bookmarks) == 0 {
+		s.updateStatus("No bookmarks set")
+		return
+	}
+	s.pendingJmp = true
+	s.updateStatus(fmt.Sprintf("Jump to bookmark: press 1-%d", min(len(s.bookmarks), bookmarkSlots)))
+}
+
+// jumpToSlot jumps to the nth bookmark, counting from 1 in the order they
+// are listed.
+func (s *AppState) jumpToSlot(n int) {
+	if n < 1 || n > len(s.bookmarks) {
+		s.updateStatus(fmt.Sprintf("No bookmark %d; there are %d", n, len(s.bookmarks)))
+		return
+	}
+	s.jumpToBookmark(s.bookmarks[n-1])
+}
+
+// jumpToBookmark enters a directory bookmark, or the directory holding a
+// file bookmark with the file selected.
+func (s *AppState) jumpToBookmark(b bookmark) {
+	if !b.file {
+		s.changeDir(b.path)
+		return
+	}
+	if _, err := os.Lstat(b.path); err != nil {
+		s.showModal("Bookmarked file is gone: "+b.path, []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	s.revealPath(b.path)
+}
+
+// fileToBookmark copies or moves the marked entries, or the one under the
+// cursor, into a directory bookmark picked from a list.
+func (s *AppState) fileToBookmark() {
+	paths := s.markedPaths()
+	if len(paths) == 0 {
+		if path := s.selectedPath(); path != "" {
+			paths = []string{path}
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	var dirs []string
+	for _, b := range s.bookmarks {
+		if !b.file {
+			dirs = append(dirs, b.path)
+		}
+	}
+	if len(dirs) == 0 {
+		s.showModal("No directory bookmarks set", []string{"OK"}, func(_ int, _ string) {})
+		return
+	}
+	what := filepath.Base(paths[0])
+	if len(paths) > 1 {
+		what = fmt.Sprintf("%d entries", len(paths))
+	}
+	list := tview.NewList().ShowSecondaryText(false)
+	for i, dir := range dirs {
+		var shortcut rune
+		if i < 9 {
+			shortcut = rune('1' + i)
+		}
+		list.AddItem(tview.Escape(dir), "", shortcut, func() {
+			_ = s.app.SetRoot(s.layout(), true)
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				s.showModal("Bookmarked directory is gone: "+dir, []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+			s.showModal("Copy or move "+what+" into "+dir+"?", []string{"Copy", "Move", "Cancel"}, func(_ int, label string) {
+				switch label {
+				case "Copy":
+					s.copyInto(paths, dir)
+				case "Move":
+					s.moveInto(paths, dir)
+				}
+			})
+		})
+	}
+	list.SetDoneFunc(func() { _ = s.app.SetRoot(s.layout(), true) })
+	list.SetBorder(true).SetTitle("Copy or move " + tview.Escape(what) + " to")
+	_ = s.app.SetRoot(list, true)
+}
+
+// Tags
+
+// tagColors are the tags entries can be given; each is shown in its color.
+var tagColors = []string{"red", "orange", "yellow", "green", "blue", "purple", "gray"}
+
+func tagsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tags.json"), nil
+}
+
+// loadTags reads the saved tags; a missing or unreadable file means none.
+func loadTags() map[string]string {
+	tags := make(map[string]string)
+	if path, err := tagsPath(); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			_ = json.Unmarshal(data, &tags)
+		}
+	}
+	return tags
+}
+
+func (s *AppState) saveTags() {
+	path, err := tagsPath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	var data []byte
+	if err == nil {
+		data, err = json.MarshalIndent(s.tags, "", "  ")
+	}
+	if err == nil {
+		err = os.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		s.updateStatus("Could not save tags: " + err.Error())
+	}
+}
+
+// pickTag lists the tags, with "none" first when withNone is set, and
+// calls done with the chosen one ("" for none). Digits pick directly.
+func (s *AppState) pickTag(title string, withNone bool, done func(tag string)) {
+	list := tview.NewList().ShowSecondaryText(false)
+	pick := func(tag string) func() {
+		return func() {
+			_ = s.app.SetRoot(s.layout(), true)
+			done(tag)
+		}
+	}
+	if withNone {
+		list.AddItem("none", "", '0', pick(""))
+	}
+	for i, tag := range tagColors {
+		list.AddItem("["+tag+"]"+tag+"[-]", "", rune('1'+i), pick(tag))
+	}
+	list.SetDoneFunc(func() { _ = s.app.SetRoot(s.layout(), true) })
+	list.SetBorder(true).SetTitle(title)
+	_ = s.app.SetRoot(list, true)
+}
+
+// tagSelection sets or removes the tag of the marked entries, or the one
+// under the cursor. Tags are only notes kept beside the files.
+func (s *AppState) tagSelection() {
+	paths := s.markedPaths()
+	if len(paths) == 0 {
+		if path := s.selectedPath(); path != "" {
+			paths = []string{path}
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	s.pickTag(fmt.Sprintf("Tag %d entries", len(paths)), true, func(tag string) {
+		for _, path := range paths {
+			if tag == "" {
+				delete(s.tags, path)
+			} else {
+				s.tags[path] = tag
+			}
+		}
+		s.saveTags()
+		if path := s.selectedPath(); path != "" {
+			s.pendingSelect = filepath.Base(path)
+		}
+		s.rebuildList(0)
+		if tag == "" {
+			s.updateStatus(fmt.Sprintf("Removed the tag from %d entries", len(paths)))
+		} else {
+			s.updateStatus(fmt.Sprintf("Tagged %d entries %s", len(paths), tag))
+		}
+	})
+}
+
+// filterByTag lists only the entries with a chosen tag, until Esc or the
+// next directory change.
+func (s *AppState) filterByTag() {
+	s.pickTag("Show only", false, func(tag string) {
+		s.tagFilter = tag
+		s.rebuildList(0)
+	})
+}
+
+// Search
+
+func (s *AppState) promptSearch() {
+	s.askInput("Search (* ? for globs, re: for a regexp)", "Filter filenames:", s.filter.term, func(text string, ok bool) {
+		if !ok {
+			return
+		}
+		f, err := parseFilter(text)
+		if err != nil {
+			s.showModal("Bad filter: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		f.invert = s.filter.invert
+		s.filter = f
+		s.refreshList()
+	})
+}
+
+// clearFilters drops the name filter and the dirs/files quick filter,
+// keeping the cursor on the same entry. It reports whether there was
+// anything to clear.
+func (s *AppState) clearFilters() bool {
+	if !s.filter.active() && !s.filter.invert && s.showType == showAll && s.tagFilter == "" {
+		return false
+	}
+	s.filter = nameFilter{}
+	s.showType = showAll
+	s.tagFilter = ""
+	if path := s.selectedPath(); path != "" {
+		s.pendingSelect = filepath.Base(path)
+	}
+	s.rebuildList(0)
+	s.updateStatus("Filters cleared")
+	return true
+}
+
+// toggleInvertFilter shows the entries the filter rejects instead of the
+// ones it accepts.
+func (s *AppState) toggleInvertFilter() {
+	s.filter.invert = !s.filter.invert
+	s.rebuildList(0)
+	if !s.filter.active() {
+		s.updateStatus("Filter will be inverted once one is set")
+	}
+}
+
+// nameFilter is the list filter. The term is matched case-insensitively
+// as a substring, as a glob when it has wildcards, or as a regular
+// expression when it starts with "re:". Brackets only make a character
+// class in a glob; on their own they are matched as typed, so names such
+// as "[draft] notes.txt" can be filtered for.
+type nameFilter struct {
+	term   string
+	glob   bool
+	re     *regexp.Regexp
+	invert bool // keep the entries that don't match
+}
+
+func parseFilter(term string) (nameFilter, error) {
+	f := nameFilter{term: term}
+	switch {
+	case strings.HasPrefix(term, "re:"):
+		re, err := regexp.Compile("(?i)" + strings.TrimPrefix(term, "re:"))
+		if err != nil {
+			return f, err
+		}
+		f.re = re
+	case strings.ContainsAny(term, "*?"):
+		if _, err := path.Match(term, ""); err != nil {
+			return f, err
+		}
+		f.glob = true
+	}
+	return f, nil
+}
+
+func (f nameFilter) active() bool {
+	return f.term != ""
+}
+
+func (f nameFilter) mode() string {
+	switch {
+	case f.re != nil:
+		return "regexp"
+	case f.glob:
+		return "glob"
+	}
+	return "name"
+}
+
+func (f nameFilter) matches(name string) bool {
+	var ok bool
+	switch {
+	case f.re != nil:
+		ok = f.re.MatchString(name)
+	case f.glob:
+		ok, _ = path.Match(strings.ToLower(f.term), strings.ToLower(name))
+	default:
+		ok = strings.Contains(strings.ToLower(name), strings.ToLower(f.term))
+	}
+	return ok != f.invert
+}
+
+// Recursive search
+
+type searchResult struct {
+	path string
+	line int // 1-based line of a content match, 0 for name matches
+	text string
+}
+
+type searchProgress struct {
+	scanned, matched int
+	unreadable       int // entries skipped because they could not be read
+	done             bool
+	err              error
+}
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+func (s *AppState) promptFind(content bool) {
+	title, label := "Find", "Name contains:"
+	if content {
+		title, label = "Find in files", "Text contains:"
+	}
+	s.askInput(title, label, "", func(text string, ok bool) {
+		if !ok || text == "" {
+			return
+		}
+		s.runSearch(text, content)
+	})
+}
+
+// runSearch walks currentDir in the background looking for term in file
+// names (or contents), cancelling any search still in flight. Progress goes
+// over a channel to a single status bar updater; the results open in a list
+// once the walk finishes.
+func (s *AppState) runSearch(term string, content bool) {
+	if s.searchCancel != nil {
+		s.searchCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.searchCancel = cancel
+	root := s.currentDir
+	progress := make(chan searchProgress, 16)
+	go s.reportSearchProgress(progress)
+	go func() {
+		results, err := searchTree(ctx, root, term, content, s.useGitIgnore(), progress)
+		close(progress)
+		if err != nil {
+			return
+		}
+		s.app.QueueUpdateDraw(func() {
+			if ctx.Err() == nil {
+				s.showSearchResults(root, term, results)
+			}
+		})
+	}()
+}
+
+// reportSearchProgress animates a spinner with the latest counts until the
+// search reports that it is done.
+func (s *AppState) reportSearchProgress(progress <-chan searchProgress) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var last searchProgress
+	frame := 0
+	for {
+		select {
+		case p, ok := <-progress:
+			if !ok {
+				return
+			}
+			last = p
+			if !p.done {
+				continue
+			}
+			switch {
+			case errors.Is(p.err, context.Canceled):
+				s.updateStatus("Search cancelled")
+			case p.err != nil:
+				s.updateStatus("Search failed: " + p.err.Error())
+			default:
+				msg := fmt.Sprintf("Search done: scanned %d / matched %d", p.scanned, p.matched)
+				if p.unreadable > 0 {
+					msg += fmt.Sprintf(" / %d unreadable", p.unreadable)
+				}
+				s.updateStatus(msg)
+			}
+			return
+		case <-ticker.C:
+			frame++
+			s.updateStatus(fmt.Sprintf("%s Searching: scanned %d / matched %d",
+				spinnerFrames[frame%len(spinnerFrames)], last.scanned, last.matched))
+		}
+	}
+}
+
+// searchTree finds files under root whose name (or, with content set, a
+// line of text) contains term, ignoring case. With useIgnore set, paths
+// excluded by .gitignore are skipped. Counts are sent on progress without
+// blocking the walk; the final report, with done set, always goes out.
+// Entries that are deleted while the walk is under way are passed over
+// quietly; others that can't be read are skipped too, but counted.
+func searchTree(ctx context.Context, root, term string, content, useIgnore bool, progress chan<- searchProgress) ([]searchResult, error) {
+	var ignore *gitIgnore
+	if useIgnore {
+		ignore = newGitIgnore(root)
+	}
+	needle := strings.ToLower(term)
+	var results []searchResult
+	scanned, unreadable := 0, 0
+	skip := func(err error) {
+		if !errors.Is(err, fs.ErrNotExist) {
+			unreadable++
+		}
+	}
+	report := func() {
+		select {
+		case progress <- searchProgress{scanned: scanned, matched: len(results)}:
+		default:
+		}
+	}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if p == root {
+				return err
+			}
+			// unreadable entries don't spoil the rest of the walk
+			skip(err)
+			return nil
+		}
+		if p == root {
+			return nil
+		}
+		if ignore.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			ignore.load(p)
+			// directories can only match by name
+			if !content && strings.Contains(strings.ToLower(d.Name()), needle) {
+				results = append(results, searchResult{path: p})
+			}
+			return nil
+		}
+		scanned++
+		if content {
+			if isTextFile(p) {
+				hits, err := grepFile(p, needle)
+				if err != nil {
+					skip(err)
+				}
+				results = append(results, hits...)
+			}
+		} else if strings.Contains(strings.ToLower(d.Name()), needle) {
+			results = append(results, searchResult{path: p})
+		}
+		report()
+		if len(results) >= SearchMaxResults {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if len(results) > SearchMaxResults {
+		results = results[:SearchMaxResults]
+	}
+	progress <- searchProgress{scanned: scanned, matched: len(results), unreadable: unreadable, done: true, err: err}
+	return results, err
+}
+
+// .gitignore handling
+
+// gitIgnore matches paths against the .gitignore files of a git work tree.
+// Walks add each directory's rules as they enter it, and a rule only
+// applies below the directory its file came from. A nil *gitIgnore ignores
+// nothing.
+type gitIgnore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	base     string // directory holding the .gitignore
+	pattern  string // slash separated, without the leading '!' or '/'
+	negate   bool   // '!' re-includes what an earlier rule excluded
+	dirOnly  bool   // trailing '/' only matches directories
+	anchored bool   // contains a '/', so it is matched from base
+}
+
+// newGitIgnore returns the matcher for a walk of root, or nil when root is
+// not inside a git work tree. The .gitignore files from the top of the tree
+// down to root are loaded up front.
+func newGitIgnore(root string) *gitIgnore {
+	top := gitTopLevel(root)
+	if top == "" {
+		return nil
+	}
+	var dirs []string
+	for d := root; ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if d == top {
+			break
+		}
+	}
+	g := &gitIgnore{}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		g.load(dirs[i])
+	}
+	return g
+}
+
+// gitTopLevel returns the nearest directory at or above dir that holds a
+// .git entry, or "" if there is none.
+func gitTopLevel(dir string) string {
+	for {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// load adds the rules from dir's .gitignore, if it has one.
+func (g *gitIgnore) load(dir string) {
+	if g == nil {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{base: dir}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			// "\#" and "\!" stand for a literal first character
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		g.rules = append(g.rules, r)
+	}
+}
+
+// ignored reports whether p is excluded. The last matching rule wins, as in
+// git. The .git directory itself is always skipped.
+func (g *gitIgnore) ignored(p string, isDir bool) bool {
+	if g == nil {
+		return false
+	}
+	if isDir && filepath.Base(p) == ".git" {
+		return true
+	}
+	ignored := false
+	for _, r := range g.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(r.base, p)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if !r.anchored {
+			rel = path.Base(rel)
+		}
+		if matchGlob(strings.Split(r.pattern, "/"), strings.Split(rel, "/")) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// matchGlob matches path segments against pattern segments, where a "**"
+// segment matches any number of path segments.
+func matchGlob(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchGlob(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// grepFile returns the lines of path that contain needle (already lower
+// case). A line too long to scan ends the search of the file without an
+// error, as it is most likely not text.
+func grepFile(path, needle string) ([]searchResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var results []searchResult
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if strings.Contains(strings.ToLower(text), needle) {
+			results = append(results, searchResult{path: path, line: line, text: strings.TrimSpace(text)})
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, bufio.ErrTooLong) {
+		return results, err
+	}
+	return results, nil
+}
+
+// searchView remembers the last search so its results can be reopened
+// after jumping to one of them.
+type searchView struct {
+	root, term string
+	results    []searchResult
+	current    int
+}
+
+// showSearchResults lists the hits above the normal browser. Enter jumps to
+// a hit and closes the results; 'p' only reveals the hit's directory in the
+// browser below, keeping the results open. KeyResults brings them back.
+func (s *AppState) showSearchResults(root, term string, results []searchResult) {
+	if len(results) == 0 {
+		s.updateStatus("No matches for " + term)
+		return
+	}
+	s.lastSearch = &searchView{root: root, term: term, results: results}
+	s.openSearchView()
+}
+
+func (s *AppState) openSearchView() {
+	view := s.lastSearch
+	if view == nil {
+		s.updateStatus("No search results")
+		return
+	}
+	list := tview.NewList().ShowSecondaryText(false)
+	for _, r := range view.results {
+		r := r
+		rel, err := filepath.Rel(view.root, r.path)
+		if err != nil {
+			rel = r.path
+		}
+		label := tview.Escape(rel)
+		if r.line > 0 {
+			label = fmt.Sprintf("%s:%d: %s", tview.Escape(rel), r.line, tview.Escape(r.text))
+		}
+		list.AddItem(label, "", 0, func() {
+			view.current = list.GetCurrentItem()
+			_ = s.app.SetRoot(s.layout(), true)
+			s.lastMatch = r
+			s.revealPath(r.path)
+		})
+	}
+	list.SetCurrentItem(view.current)
+	list.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() != 'p' {
+			return event
+		}
+		view.current = list.GetCurrentItem()
+		s.lastMatch = view.results[view.current]
+		s.revealPath(s.lastMatch.path)
+		return nil
+	})
+	list.SetDoneFunc(func() {
+		view.current = list.GetCurrentItem()
+		_ = s.app.SetRoot(s.layout(), true)
+	})
+	list.SetBorder(true).SetTitle(fmt.Sprintf("Results for %s (%d) - Enter: go to, p: reveal, Esc: close", tview.Escape(strconv.Quote(view.term)), len(view.results)))
+
+	root := tview.NewFlex().SetDirection(tview.FlexRow)
+	root.AddItem(list, 0, 1, true)
+	root.AddItem(s.layout(), 0, 2, false)
+	_ = s.app.SetRoot(root, true)
+}
+
+// revealPath navigates to the directory holding path and puts the cursor on
+// it.
+func (s *AppState) revealPath(path string) {
+	s.pendingSelect = filepath.Base(path)
+	s.changeDir(filepath.Dir(path))
+}
+
+// selectName moves the cursor to the entry called name, if it is listed.
+func (s *AppState) selectName(name string) {
+	for i, n := range s.itemNames {
+		if n == name {
+			s.filesList.SetCurrentItem(i)
+			return
+		}
+	}
+}
+
+// Fuzzy finder
+
+// fuzzyIndex holds the relative paths below a root. It is filled by a
+// background walk and may be incomplete if that walk was cancelled, in which
+// case the next walk resumes after the last path recorded.
+type fuzzyIndex struct {
+	walk     sync.Mutex // held by the walk filling the index
+	mu       sync.Mutex
+	paths    []string             // relative to the root, in walk order
+	dirTimes map[string]time.Time // modtime of every directory walked
+	complete bool
+}
+
+// snapshot returns the paths found so far. The slice is append-only, so
+// handing out a capped view is safe.
+func (x *fuzzyIndex) snapshot() ([]string, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.paths[:len(x.paths):len(x.paths)], x.complete
+}
+
+// stale reports whether any directory in the index has changed.
+func (x *fuzzyIndex) stale(root string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for dir, mod := range x.dirTimes {
+		info, err := os.Stat(filepath.Join(root, dir))
+		if err != nil || !info.ModTime().Equal(mod) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *AppState) useGitIgnore() bool {
+	return RespectGitIgnore && !s.withIgnored
+}
+
+// toggleIgnored switches whether searches look inside .gitignored paths.
+// Fuzzy indexes built the other way are thrown away.
+func (s *AppState) toggleIgnored() {
+	s.withIgnored = !s.withIgnored
+	s.fuzzyLock.Lock()
+	s.fuzzyCache = make(map[string]*fuzzyIndex)
+	s.fuzzyLock.Unlock()
+	if s.useGitIgnore() {
+		s.updateStatus("Searches skip .gitignored files")
+	} else {
+		s.updateStatus("Searches include .gitignored files")
+	}
+}
+
+func (s *AppState) fuzzyIndexFor(root string) *fuzzyIndex {
+	s.fuzzyLock.Lock()
+	defer s.fuzzyLock.Unlock()
+	idx := s.fuzzyCache[root]
+	if idx == nil {
+		idx = &fuzzyIndex{dirTimes: make(map[string]time.Time)}
+		s.fuzzyCache[root] = idx
+	}
+	return idx
+}
+
+// buildFuzzyIndex walks root and streams paths into idx, calling notify as
+// it goes. A complete index is reused unless a directory in it changed; a
+// partial one is resumed from its last path.
+func buildFuzzyIndex(ctx context.Context, root string, idx *fuzzyIndex, useIgnore bool, notify func()) {
+	// a cancelled walk may still be winding down; don't interleave with it
+	idx.walk.Lock()
+	defer idx.walk.Unlock()
+
+	if _, complete := idx.snapshot(); complete {
+		if !idx.stale(root) {
+			notify()
+			return
+		}
+		idx.mu.Lock()
+		idx.paths = nil
+		idx.dirTimes = make(map[string]time.Time)
+		idx.complete = false
+		idx.mu.Unlock()
+	}
+	idx.mu.Lock()
+	last := ""
+	if n := len(idx.paths); n > 0 {
+		last = idx.paths[n-1]
+	}
+	idx.mu.Unlock()
+
+	var ignore *gitIgnore
+	if useIgnore {
+		ignore = newGitIgnore(root)
+	}
+	lastNotify := time.Now()
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			return nil
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil || rel == "." {
+			return nil
+		}
+		if ignore.ignored(p, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if last != "" && comparePaths(rel, last) <= 0 {
+			// already indexed; only descend towards where we stopped
+			if d.IsDir() && rel != last && !isAncestor(rel, last) {
+				return filepath.SkipDir
+			}
+			if d.IsDir() {
+				ignore.load(p)
+			}
+			return nil
+		}
+		if d.IsDir() {
+			ignore.load(p)
+		}
+		idx.mu.Lock()
+		idx.paths = append(idx.paths, rel)
+		if d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				idx.dirTimes[rel] = info.ModTime()
+			}
+		}
+		idx.mu.Unlock()
+		if time.Since(lastNotify) > 100*time.Millisecond {
+			lastNotify = time.Now()
+			notify()
+		}
+		return nil
+	})
+	if err == nil {
+		idx.mu.Lock()
+		if info, err := os.Stat(root); err == nil {
+			idx.dirTimes["."] = info.ModTime()
+		}
+		idx.complete = true
+		idx.mu.Unlock()
+	}
+	notify()
+}
+
+// comparePaths orders relative paths the way filepath.WalkDir visits them:
+// component by component, parents before children.
+func comparePaths(a, b string) int {
+	pa := strings.Split(a, string(filepath.Separator))
+	pb := strings.Split(b, string(filepath.Separator))
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if c := strings.Compare(pa[i], pb[i]); c != 0 {
+			return c
+		}
+	}
+	return len(pa) - len(pb)
+}
+
+func isAncestor(dir, path string) bool {
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// fuzzyScore reports whether the characters of query appear in order in
+// candidate (ignoring case) and how good the match is. Consecutive runs and
+// matches at the start of a path component score higher; shorter paths win
+// ties.
+func fuzzyScore(candidate, query string) (int, bool) {
+	c := []rune(strings.ToLower(candidate))
+	q := []rune(strings.ToLower(query))
+	score, qi, prev := 0, 0, -2
+	for i := 0; i < len(c) && qi < len(q); i++ {
+		if c[i] != q[qi] {
+			continue
+		}
+		score++
+		if prev == i-1 {
+			score += 5
+		}
+		if i == 0 || c[i-1] == filepath.Separator || c[i-1] == '.' || c[i-1] == '_' || c[i-1] == '-' {
+			score += 8
+		}
+		prev = i
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score*100 - len(c), true
+}
+
+type fuzzyMatch struct {
+	path  string
+	score int
+}
+
+// fuzzyFilter returns up to limit paths matching query, best first.
+func fuzzyFilter(paths []string, query string, limit int) []string {
+	if query == "" {
+		if len(paths) > limit {
+			paths = paths[:limit]
+		}
+		return paths
+	}
+	var matches []fuzzyMatch
+	for _, p := range paths {
+		if score, ok := fuzzyScore(p, query); ok {
+			matches = append(matches, fuzzyMatch{path: p, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = m.path
+	}
+	return out
+}
+
+// openFuzzyFinder shows a query field over a live-filtered list of every
+// path below currentDir. Paths stream in while the index is built; closing
+// the finder cancels the walk and keeps what was found for next time.
+func (s *AppState) openFuzzyFinder() {
+	root := s.currentDir
+	idx := s.fuzzyIndexFor(root)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	input := tview.NewInputField().SetLabel("> ")
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true)
+	var shown []string
+	update := func() {
+		paths, complete := idx.snapshot()
+		shown = fuzzyFilter(paths, input.GetText(), 200)
+		list.Clear()
+		for _, p := range shown {
+			list.AddItem(tview.Escape(p), "", 0, nil)
+		}
+		title := fmt.Sprintf("Fuzzy find (%d of %d)", len(shown), len(paths))
+		if !complete {
+			title += " indexing..."
+		}
+		list.SetTitle(title)
+	}
+	closeFinder := func() {
+		cancel()
+		_ = s.app.SetRoot(s.layout(), true)
+	}
+	input.SetChangedFunc(func(string) { update() })
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			list.InputHandler()(event, func(p tview.Primitive) {})
+			return nil
+		}
+		return event
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			i := list.GetCurrentItem()
+			if i < 0 || i >= len(shown) {
+				return
+			}
+			closeFinder()
+			s.revealPath(filepath.Join(root, shown[i]))
+		case tcell.KeyEscape:
+			closeFinder()
+		}
+	})
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow)
+	layout.AddItem(input, 1, 0, true)
+	layout.AddItem(list, 0, 1, false)
+	update()
+	_ = s.app.SetRoot(layout, true)
+
+	go buildFuzzyIndex(ctx, root, idx, s.useGitIgnore(), func() {
+		s.app.QueueUpdateDraw(func() {
+			if ctx.Err() == nil {
+				update()
+			}
+		})
+	})
+}
+
+// Activity log
+
+type activityEntry struct {
+	when   time.Time
+	op     string
+	detail string
+	err    error
+}
+
+func (e activityEntry) String() string {
+	return e.when.Format(TimeLayout) + "  " + e.describe()
+}
+
+// describe is the entry without its time.
+func (e activityEntry) describe() string {
+	outcome := "ok"
+	if e.err != nil {
+		outcome = "failed: " + e.err.Error()
+	}
+	return fmt.Sprintf("%-11s %s  [%s]", e.op, e.detail, outcome)
+}
+
+// logActivity records a file operation and its outcome, dropping the
+// oldest entries past ActivityLogMax. It must run on the UI goroutine.
+func (s *AppState) logActivity(op, detail string, err error) {
+	e := activityEntry{when: time.Now(), op: op, detail: detail, err: err}
+	s.activity = append(s.activity, e)
+	if n := len(s.activity) - ActivityLogMax; n > 0 {
+		s.activity = append(s.activity[:0], s.activity[n:]...)
+	}
+	if ActivityLogFile == "" {
+		return
+	}
+	f, ferr := os.OpenFile(ActivityLogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if ferr != nil {
+		return
+	}
+	defer f.Close()
+	// the file keeps a fixed layout so it stays easy to parse
+	fmt.Fprintln(f, e.when.Format(time.DateTime)+"  "+e.describe())
+}
+
+// showActivity lists the logged operations, newest at the bottom.
+func (s *AppState) showActivity() {
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	view.SetBorder(true).SetTitle(fmt.Sprintf("Activity (%d) - Esc to close", len(s.activity)))
+	var text strings.Builder
+	for _, e := range s.activity {
+		line := tview.Escape(e.String())
+		if e.err != nil {
+			line = "[red]" + line + "[-]"
+		}
+		text.WriteString(line + "\n")
+	}
+	if len(s.activity) == 0 {
+		text.WriteString("No file operations yet.")
+	}
+	view.SetText(text.String()).ScrollToEnd()
+	s.showPager(view)
+}
+
+// showPager shows a scrollable text view full screen until Esc, Enter or
+// 'q' is pressed.
+func (s *AppState) showPager(view *tview.TextView) {
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEsc || event.Key() == tcell.KeyEnter || event.Rune() == 'q' {
+			_ = s.app.SetRoot(s.layout(), true)
+			return nil
+		}
+		return event
 	})
+	_ = s.app.SetRoot(view, true)
 }
 
-func (s *AppState) moveSelection() {
-	idx := s.filesList.GetCurrentItem()
-	if idx < 0 {
+// Diff
+
+// backupSuffixes mark a file as a backup of the same name without them.
+var backupSuffixes = []string{".orig", ".bak", "~"}
+
+// diffSelection compares the two marked files, or the file under the
+// cursor with its backup (or, for a backup, with the original). Without
+// one, it asks which file to compare against.
+func (s *AppState) diffSelection() {
+	if marked := s.markedPaths(); len(marked) > 0 {
+		if len(marked) != 2 {
+			s.showModal(fmt.Sprintf("Mark exactly two files to compare them (%d marked)", len(marked)), []string{"OK"}, func(_ int, _ string) {})
+			return
+		}
+		for _, p := range marked {
+			if info, err := os.Stat(p); err != nil || !info.Mode().IsRegular() {
+				s.showModal("Cannot compare "+filepath.Base(p)+": not a regular file", []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+		}
+		s.showDiff(marked[0], marked[1])
 		return
 	}
-	label, _ := s.filesList.GetItemText(idx)
-	name := strings.TrimPrefix(label, "[::b][DIR] ")
-	old := filepath.Join(s.currentDir, name)
-	s.askInput("Move to", "Destination path:", filepath.Join(s.currentDir, name), func(text string, ok bool) {
-		if !ok || strings.TrimSpace(text) == "" {
-			return
+	path := s.selectedPath()
+	if path == "" {
+		return
+	}
+	if other := backupPair(path); other != "" {
+		if strings.HasPrefix(filepath.Base(path), filepath.Base(other)) {
+			// path is the backup; show the changes made since
+			path, other = other, path
 		}
-		err := os.Rename(old, text)
-		if err != nil {
-			s.showModal("Move failed: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+		s.showDiff(other, path)
+		return
+	}
+	s.askDest("Compare "+filepath.Base(path)+" with", s.currentDir+string(filepath.Separator), func(text string, ok bool) {
+		if !ok || strings.TrimSpace(text) == "" {
 			return
 		}
-		s.updateStatus("Moved to: " + text)
-		s.refreshList()
+		s.showDiff(path, s.resolveDest(text, ""))
 	})
 }
 
-func copyPath(src, dst string) error {
-	info, err := os.Stat(src)
-	if err != nil {
-		return err
+// backupPair returns the sibling that path is a backup of, or that is a
+// backup of path, if there is one.
+func backupPair(path string) string {
+	for _, suffix := range backupSuffixes {
+		if orig, ok := strings.CutSuffix(path, suffix); ok && orig != "" {
+			if _, err := os.Stat(orig); err == nil {
+				return orig
+			}
+		}
 	}
-	if info.IsDir() {
-		// copy directory recursively
-		return copyDir(src, dst)
+	for _, suffix := range backupSuffixes {
+		if _, err := os.Stat(path + suffix); err == nil {
+			return path + suffix
+		}
 	}
-	// copy file
-	in, err := os.Open(src)
+	return ""
+}
+
+// showDiff shows a coloured unified diff from a to b full screen. The
+// files are read in the background, up to the preview size limits.
+func (s *AppState) showDiff(a, b string) {
+	s.updateStatus("Comparing...")
+	go func() {
+		linesA, cutA, errA := readDiffLines(a)
+		linesB, cutB, errB := readDiffLines(b)
+		s.app.QueueUpdateDraw(func() {
+			if err := errors.Join(errA, errB); err != nil {
+				s.showModal("Cannot compare: "+err.Error(), []string{"OK"}, func(_ int, _ string) {})
+				return
+			}
+			var text strings.Builder
+			fmt.Fprintf(&text, "[::b]--- %s\n+++ %s[::-]\n", tview.Escape(a), tview.Escape(b))
+			diff := unifiedDiff(linesA, linesB, 3)
+			if len(diff) == 0 {
+				text.WriteString("(no differences)\n")
+			}
+			for _, line := range diff {
+				switch {
+				case strings.HasPrefix(line, "@@"):
+					text.WriteString("[cyan]" + tview.Escape(line) + "[-]\n")
+				case strings.HasPrefix(line, "+"):
+					text.WriteString("[green]" + tview.Escape(line) + "[-]\n")
+				case strings.HasPrefix(line, "-"):
+					text.WriteString("[red]" + tview.Escape(line) + "[-]\n")
+				default:
+					text.WriteString(tview.Escape(line) + "\n")
+				}
+			}
+			if cutA || cutB {
+				text.WriteString("\n... (compared the first part of the files only)\n")
+			}
+			view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetText(text.String())
+			view.SetBorder(true).SetTitle("Diff - Esc to close")
+			s.updateStatus("Ready")
+			s.showPager(view)
+		})
+	}()
+}
+
+// readDiffLines reads the lines of a text file within PreviewMaxBytes and
+// TextPreviewLines, reporting whether it stopped short. Files with NUL
+// bytes are treated as binary and refused.
+func readDiffLines(path string) ([]string, bool, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, false, err
 	}
-	defer in.Close()
-	out, err := os.Create(dst)
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(PreviewMaxBytes)+1))
 	if err != nil {
-		return err
+		return nil, false, err
 	}
-	defer out.Close()
-	if _, err := io.Copy(out, in); err != nil {
-		return err
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, false, fmt.Errorf("%s is a binary file", filepath.Base(path))
+	}
+	cut := len(data) > PreviewMaxBytes
+	if cut {
+		data = data[:PreviewMaxBytes]
 	}
-	return out.Sync()
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	if len(lines) > TextPreviewLines {
+		lines, cut = lines[:TextPreviewLines], true
+	}
+	return lines, cut, nil
 }
 
-func copyDir(src, dst string) error {
-	entries, err := os.ReadDir(src)
-	if err != nil {
-		return err
+// unifiedDiff returns the hunks turning a into b, each line prefixed with
+// ' ', '-' or '+', and context unchanged lines around every change. It
+// uses a longest-common-subsequence table, which is fine at preview sizes.
+func unifiedDiff(a, b []string, context int) []string {
+	n, m := len(a), len(b)
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
 	}
-	if err := os.MkdirAll(dst, 0755); err != nil {
-		return err
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
 	}
-	for _, e := range entries {
-		srcPath := filepath.Join(src, e.Name())
-		dstPath := filepath.Join(dst, e.Name())
-		if e.IsDir() {
-			if err := copyDir(srcPath, dstPath); err != nil {
-				return err
+	type edit struct {
+		op   byte
+		text string
+		i, j int // line numbers in a and b before this edit
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < n && (j == m || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', a[i], i, j})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	var out []string
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		// a hunk runs from context lines before this change to context
+		// lines after the last change that is close enough to join it
+		start := max(k-context, 0)
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
 			}
-		} else {
-			if err := copyPath(srcPath, dstPath); err != nil {
-				return err
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				end = min(end+context, len(edits))
+				break
 			}
+			end = next
 		}
+		var countA, countB int
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				countA++
+			}
+			if e.op != '-' {
+				countB++
+			}
+		}
+		// an empty side is numbered from the line before it, as diff does
+		lineA, lineB := edits[start].i+1, edits[start].j+1
+		if countA == 0 {
+			lineA--
+		}
+		if countB == 0 {
+			lineB--
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", lineA, countA, lineB, countB))
+		for _, e := range edits[start:end] {
+			out = append(out, string(e.op)+e.text)
+		}
+		k = end
 	}
-	return nil
+	return out
 }
 
-// Bookmarks
+// Sorting
 
-func (s *AppState) toggleBookmark() {
-	for i, b := range s.bookmarks {
-		if b == s.currentDir {
-			// remove
-			s.bookmarks = append(s.bookmarks[:i], s.bookmarks[i+1:]...)
-			s.updateStatus("Removed bookmark")
-			return
-		}
+func (s *AppState) toggleDirsFirst() {
+	s.dirsFirst = !s.dirsFirst
+	s.resort()
+	s.savePrefs()
+	if s.dirsFirst {
+		s.updateStatus("Directories first")
+	} else {
+		s.updateStatus("Directories mixed with files")
 	}
-	s.bookmarks = append(s.bookmarks, s.currentDir)
-	s.updateStatus("Bookmarked")
 }
 
-func (s *AppState) listBookmarks() {
-	if len(s.bookmarks) == 0 {
-		s.showModal("No bookmarks set", []string{"OK"}, func(_ int, _ string) {})
-		return
+func (s *AppState) cycleShowType() {
+	s.showType = (s.showType + 1) % 3
+	s.rebuildList(0)
+	s.updateStatus("Showing " + s.showType.String())
+}
+
+// toggleDryRun turns dry run on or off. While on, deletes, moves, copies
+// and bulk renames go through their prompts and then only show the plan.
+func (s *AppState) toggleDryRun() {
+	s.dryRun = !s.dryRun
+	if s.dryRun {
+		s.updateStatus("Dry run on: file operations only show what they would do")
+	} else {
+		s.updateStatus("Dry run off")
 	}
-	list := tview.NewList()
-	for _, b := range s.bookmarks {
-		bb := b
-		list.AddItem(b, "", 0, func() { s.changeDir(bb) })
+}
+
+// toggleFollowLinks switches between entering and copying through symlinks
+// and treating them as links.
+func (s *AppState) toggleFollowLinks() {
+	s.followLinks = !s.followLinks
+	if s.followLinks {
+		s.updateStatus("Following symlinks")
+	} else {
+		s.updateStatus("Not following symlinks; copies keep them as links")
 	}
-	list.SetDoneFunc(func() { _ = s.app.SetRoot(s.layout(), true) })
-	list.SetBorder(true).SetTitle("Bookmarks")
-	_ = s.app.SetRoot(list, true)
 }
 
-// Search
+func (s *AppState) toggleHidden() {
+	s.showHidden = !s.showHidden
+	s.rebuildList(0)
+	s.savePrefs()
+	if s.showHidden {
+		s.updateStatus("Showing hidden files")
+	} else {
+		s.updateStatus("Hiding hidden files")
+	}
+}
 
-func (s *AppState) promptSearch() {
-	s.askInput("Search", "Filter filenames:", s.searchTerm, func(text string, ok bool) {
-		if !ok {
-			return
-		}
-		s.searchTerm = text
-		s.refreshList()
-	})
+func (s *AppState) toggleExtColumn() {
+	s.columns.ext = !s.columns.ext
+	s.rebuildList(s.filesList.GetCurrentItem())
+}
+
+func (s *AppState) cycleSortMode() {
+	s.sortMode = (s.sortMode + 1) % sortModeCount
+	s.resort()
+	s.savePrefs()
+	s.updateStatus("Sort by " + s.sortMode.String())
+}
+
+func (s *AppState) toggleSortReverse() {
+	s.sortReverse = !s.sortReverse
+	s.resort()
+	s.savePrefs()
+	if s.sortReverse {
+		s.updateStatus("Reverse sort")
+	} else {
+		s.updateStatus("Normal sort")
+	}
 }
 
 // Help
 
 func (s *AppState) showHelp() {
-	help := `[::b]Keys[-]
+	var help strings.Builder
+	// showModal escapes its text, so no color tags here
+	help.WriteString(`Keys
 
 Up/Down - Navigate
-Enter - Open directory / preview file
+Home/End, gg/G - First / last entry
+PgUp/PgDn, Ctrl-U/Ctrl-D - Page up / down
+Enter - Open directory / preview file (or open it, with EnterOpensFile)
 Backspace - Go up
-` + fmt.Sprintf("'%c' - Open with system default\n'%c' - Delete\n'%c' - Rename\n'%c' - Copy\n'%c' - Move\n'%c' - Bookmark toggle\n'%c' - List bookmarks\n'%c' - Search\n'%c' - Help\n'%c' - Quit\n",
-		KeyOpen, KeyDelete, KeyRename, KeyCopy, KeyMove, KeyBookmark, KeyListBook, KeySearch, KeyHelp, KeyQuit)
+Ctrl-P - Command palette
+Esc - Close overlays and clear filters (quits with EscQuits)
+`)
+	for _, a := range s.actions() {
+		fmt.Fprintf(&help, "%s - %s\n", keyName(a.key), a.name)
+	}
+	s.showModal(help.String(), []string{"OK"}, func(_ int, _ string) {})
+}
+
+// Actions
+
+// action is a command bound to a key. The key dispatch, the help screen
+// and the command palette are all generated from s.actions(), so a new
+// command only needs adding there.
+type action struct {
+	key  rune
+	name string
+	run  func()
+}
+
+// mutatingKeys are the actions that change files, which read-only mode
+// turns off.
+var mutatingKeys = []rune{KeyEdit, KeyDelete, KeyRestore, KeyEmptyTrash, KeyRename, KeyBulkName, KeyUndo, KeyCopy, KeyDuplicate, KeyExport, KeyMove, KeySymlink, KeyHardlink, KeyFileTo}
+
+func (s *AppState) actions() []action {
+	all := []action{
+		{KeyMark, "Mark / unmark", s.toggleMark},
+		{KeyMarked, "List or clear marks in all directories", s.listMarked},
+		{KeyPin, "Pin / unpin at the top", s.togglePin},
+		{KeyOpen, "Open with system default", s.openSelection},
+		{KeyEdit, "Edit at the previewed line", s.editSelection},
+		{KeyDiff, "Diff marked pair, or with backup or another file", s.diffSelection},
+		{KeyReveal, "Reveal in file manager", s.revealSelection},
+		{KeyDelete, "Delete", s.deleteSelection},
+		{KeyRestore, "Restore from trash", s.restoreFromTrash},
+		{KeyEmptyTrash, "Empty trash", s.emptyTrash},
+		{KeyRename, "Rename", s.renameSelection},
+		{KeyBulkName, "Bulk rename marked", s.bulkRenameSelection},
+		{KeyUndo, "Undo last rename/move", s.undo},
+		{KeyDryRun, "Dry run toggle", s.toggleDryRun},
+		{KeyActivity, "Activity log", s.showActivity},
+		{KeyOps, "Operations queue", s.showOps},
+		{KeyCopy, "Copy", s.copySelection},
+		{KeyDuplicate, "Duplicate in place", s.duplicateSelection},
+		{KeyExport, "Export listing to a file", s.exportListing},
+		{KeyMove, "Move", s.moveSelection},
+		{KeySymlink, "Create symlink", s.symlinkSelection},
+		{KeyHardlink, "Create hardlink", s.hardlinkSelection},
+		{KeyBookmark, "Bookmark toggle", s.toggleBookmark},
+		{KeyBookFile, "Bookmark selected file toggle", s.toggleFileBookmark},
+		{KeyTag, "Tag marked / selected with a color", s.tagSelection},
+		{KeyTagFilter, "Show only entries with a tag", s.filterByTag},
+		{KeyListBook, "List bookmarks", s.listBookmarks},
+		{KeyBookSlot, "Jump to bookmark 1-9 (then the number)", s.startSlotJump},
+		{KeyFileTo, "Copy / move to a bookmarked directory", s.fileToBookmark},
+		{KeyParentTab, "Open parent in a new tab", s.openParentTab},
+		{KeyNextTab, "Next tab", func() { s.cycleTab(1) }},
+		{KeyPrevTab, "Previous tab", func() { s.cycleTab(-1) }},
+		{KeyCloseTab, "Close tab", s.closeTab},
+		{KeySearch, "Search", s.promptSearch},
+		{KeyInvert, "Invert filter", s.toggleInvertFilter},
+		{KeyFind, "Find files below here", func() { s.promptFind(false) }},
+		{KeyGrep, "Find text in files below here", func() { s.promptFind(true) }},
+		{KeyResults, "Back to search results", s.openSearchView},
+		{KeyFuzzy, "Fuzzy find", s.openFuzzyFinder},
+		{KeyIgnored, "Include .gitignored files in searches toggle", s.toggleIgnored},
+		{KeyCopyPath, "Copy path to clipboard", func() {
+			if path := s.selectedPath(); path != "" {
+				s.copyPathToClipboard(path)
+			}
+		}},
+		{KeyCopyDir, "Copy current directory to clipboard", func() { s.copyPathToClipboard(s.currentDir) }},
+		{KeySort, "Cycle sort mode", s.cycleSortMode},
+		{KeyReverse, "Reverse sort", s.toggleSortReverse},
+		{KeyDirsFirst, "Directories first toggle", s.toggleDirsFirst},
+		{KeyPreview, "Preview pane toggle", s.togglePreview},
+		{KeyRaw, "Rendered / source preview of JSON, CSV, markdown", s.toggleRawPreview},
+		{KeyStats, "Preview stats line toggle", s.togglePreviewHeader},
+		{KeyMaximize, "Maximize preview", s.toggleMaximize},
+		{KeyExtColumn, "Extension column toggle", s.toggleExtColumn},
+		{KeyShowType, "Show all / dirs / files", s.cycleShowType},
+		{KeyHidden, "Hidden files toggle", s.toggleHidden},
+		{KeyLinks, "Follow symlinks toggle", s.toggleFollowLinks},
+		{KeyShrink, "Narrow file list", func() { s.resizePanes(-1) }},
+		{KeyGrow, "Widen file list", func() { s.resizePanes(1) }},
+		{KeyPalette, "Command palette", s.openPalette},
+		{KeyHelp, "Help", s.showHelp},
+		{KeyQuit, "Quit", s.app.Stop},
+	}
+	if s.readOnly {
+		for i, a := range all {
+			if slices.Contains(mutatingKeys, a.key) {
+				all[i].run = func() { s.updateStatus("Read-only mode: " + a.name + " is disabled") }
+			}
+		}
+	}
+	return all
+}
+
+func keyName(r rune) string {
+	if r == ' ' {
+		return "Space"
+	}
+	return fmt.Sprintf("'%c'", r)
+}
+
+// openPalette lists every action by name; typing filters the list and
+// Enter runs the highlighted one.
+func (s *AppState) openPalette() {
+	all := s.actions()
+	input := tview.NewInputField().SetLabel(": ")
+	list := tview.NewList().ShowSecondaryText(false)
+	list.SetBorder(true).SetTitle("Commands")
+	var shown []action
+	update := func() {
+		term := strings.ToLower(input.GetText())
+		shown = shown[:0]
+		list.Clear()
+		for _, a := range all {
+			if a.key == KeyPalette || !strings.Contains(strings.ToLower(a.name), term) {
+				continue
+			}
+			shown = append(shown, a)
+			list.AddItem(fmt.Sprintf("%-40s [gray]%s[-]", a.name, tview.Escape(keyName(a.key))), "", 0, nil)
+		}
+	}
+	input.SetChangedFunc(func(string) { update() })
+	input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+			list.InputHandler()(event, func(p tview.Primitive) {})
+			return nil
+		}
+		return event
+	})
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			i := list.GetCurrentItem()
+			if i < 0 || i >= len(shown) {
+				return
+			}
+			_ = s.app.SetRoot(s.layout(), true)
+			shown[i].run()
+			s.loadPreviewForSelection()
+		case tcell.KeyEscape:
+			_ = s.app.SetRoot(s.layout(), true)
+		}
+	})
 
-	s.showModal(help, []string{"OK"}, func(_ int, _ string) { _ = s.app.SetRoot(s.layout(), true) })
+	layout := tview.NewFlex().SetDirection(tview.FlexRow)
+	layout.AddItem(input, 1, 0, true)
+	layout.AddItem(list, 0, 1, false)
+	update()
+	_ = s.app.SetRoot(layout, true)
 }
 
 // Layout
@@ -556,27 +6708,229 @@ func (s *AppState) layout() tview.Primitive {
 
 	// right: preview
 	right := tview.NewFlex().SetDirection(tview.FlexRow)
-	right.AddItem(s.preview, 0, 1, false)
+	right.AddItem(s.preview, 0, 1, s.previewMax)
 	right.SetBorder(true).SetTitle("Preview")
 
-	// main flex
+	// main flex; the list takes the full width when the preview is hidden,
+	// and the preview does when it is maximized
 	main := tview.NewFlex().SetDirection(tview.FlexColumn)
-	main.AddItem(left, 0, 3, true)
-	main.AddItem(right, 0, 5, false)
+	if s.previewMax {
+		right.SetTitle(tview.Escape("Preview - "+filepath.Base(s.previewPath)) + " (Esc: restore, /: find, n: next)")
+		main.AddItem(right, 0, 1, true)
+	} else {
+		main.AddItem(left, 0, s.prefs.ListWeight, true)
+		if s.showPreview {
+			main.AddItem(right, 0, PaneWidthTotal-s.prefs.ListWeight, false)
+		}
+	}
 
-	// footer
-	footer := tview.NewFlex().SetDirection(tview.FlexColumn)
-	footer.AddItem(s.status, 0, 1, false)
+	// footer: the status line, then the key hints
+	footer := tview.NewFlex().SetDirection(tview.FlexRow)
+	footer.AddItem(s.status, 1, 0, false)
+	footer.AddItem(s.hints, 1, 0, false)
 	footer.SetBorder(true)
 
 	root := tview.NewFlex().SetDirection(tview.FlexRow)
 	root.AddItem(main, 0, 1, true)
-	root.AddItem(footer, 1, 0, false)
+	// two lines of text plus the border
+	root.AddItem(footer, 4, 0, false)
 	return root
 }
 
+// resizePanes moves the split between the list and the preview by delta
+// parts and remembers the result.
+func (s *AppState) resizePanes(delta int) {
+	w := clampListWeight(s.prefs.ListWeight + delta)
+	if w == s.prefs.ListWeight {
+		return
+	}
+	s.prefs.ListWeight = w
+	_ = s.app.SetRoot(s.layout(), true)
+	s.savePrefs()
+}
+
+// onDrawn runs after every screen draw. tview re-lays out and re-wraps text
+// on resize by itself, but list labels are shortened for a specific width,
+// so when the list's width has changed (terminal resize, pane resize or the
+// preview toggling) the labels are rebuilt for the new width.
+func (s *AppState) onDrawn(screen tcell.Screen) {
+	s.screen = screen
+	_, _, width, _ := s.filesList.GetInnerRect()
+	if width == s.listWidth {
+		return
+	}
+	s.listWidth = width
+	s.app.QueueUpdateDraw(func() {
+		offset, _ := s.filesList.GetOffset()
+		s.rebuildList(s.filesList.GetCurrentItem())
+		s.filesList.SetOffset(offset, 0)
+	})
+}
+
+// toggleMaximize gives the preview the whole window, for reading, and
+// back. The list keeps its selection meanwhile. Every overlay restores
+// whichever of the two layouts was showing.
+func (s *AppState) toggleMaximize() {
+	if !s.previewMax && (!s.showPreview || s.previewPath == "") {
+		s.updateStatus("Nothing previewed to maximize")
+		return
+	}
+	s.previewMax = !s.previewMax
+	_ = s.app.SetRoot(s.layout(), true)
+}
+
+// previewActions are the commands of the maximized preview, which
+// previewKeys dispatches and the key hints list. Esc restores it too.
+func (s *AppState) previewActions() []action {
+	return []action{
+		{KeyMaximize, "Restore", s.toggleMaximize},
+		{'/', "Find", func() {
+			s.askInput("Find in preview", "Find:", s.previewFind, func(text string, ok bool) {
+				if ok && text != "" {
+					s.previewFind = text
+					s.findInPreview(false)
+				}
+			})
+		}},
+		{'n', "Next match", func() { s.findInPreview(true) }},
+	}
+}
+
+// previewKeys handles keys while the preview is maximized and has focus;
+// other keys scroll it as usual.
+func (s *AppState) previewKeys(event *tcell.EventKey) *tcell.EventKey {
+	if !s.previewMax {
+		return event
+	}
+	if event.Key() == tcell.KeyEsc {
+		s.toggleMaximize()
+		return nil
+	}
+	for _, a := range s.previewActions() {
+		if event.Key() == tcell.KeyRune && event.Rune() == a.key {
+			a.run()
+			return nil
+		}
+	}
+	return event
+}
+
+// hintKeys are the list commands the key hints suggest, most useful first.
+// The rest are in the help and the command palette.
+var hintKeys = []rune{KeyHelp, KeyPalette, KeySearch, KeyMark, KeyCopy, KeyMove, KeyRename, KeyDelete, KeyPreview, KeyMaximize, KeyQuit}
+
+// renderHints fills the key hints line for focused, the primitive taking
+// focus: the file list, the maximized preview, or nil for an overlay. List
+// and preview hints come from their action registries, so they follow the
+// key settings and read-only mode. It must run on the UI goroutine.
+func (s *AppState) renderHints(focused tview.Primitive) {
+	var hints []string
+	add := func(key, name string) {
+		hints = append(hints, "[yellow]"+tview.Escape(key)+"[-] "+tview.Escape(name))
+	}
+	switch focused {
+	case s.filesList:
+		for _, key := range hintKeys {
+			if a, ok := s.keyActions[key]; ok && !(s.readOnly && slices.Contains(mutatingKeys, key)) {
+				add(keyName(key), a.name)
+			}
+		}
+	case s.preview:
+		for _, a := range s.previewActions() {
+			key := keyName(a.key)
+			if a.key == KeyMaximize {
+				key = "Esc/" + key
+			}
+			add(key, a.name)
+		}
+		add("Up/Down", "Scroll")
+	default:
+		add("Esc", "Close")
+		add("Enter", "Choose")
+		add("Up/Down", "Move")
+	}
+	s.hints.SetText(strings.Join(hints, "  "))
+}
+
+// findInPreview scrolls the preview to the next line containing
+// previewFind, case-insensitively, from the top line shown (or the one
+// after it with next set), wrapping around at the end.
+func (s *AppState) findInPreview(next bool) {
+	if s.previewFind == "" {
+		return
+	}
+	term := strings.ToLower(s.previewFind)
+	lines := strings.Split(s.preview.GetText(true), "\n")
+	row, _ := s.preview.GetScrollOffset()
+	if next {
+		row++
+	}
+	for i := range lines {
+		n := (row + i) % len(lines)
+		if strings.Contains(strings.ToLower(lines[n]), term) {
+			s.preview.ScrollTo(n, 0)
+			s.updateStatus(fmt.Sprintf("%q on line %d", s.previewFind, n+1))
+			return
+		}
+	}
+	s.updateStatus(fmt.Sprintf("%q not found", s.previewFind))
+}
+
+func (s *AppState) togglePreview() {
+	s.showPreview = !s.showPreview
+	_ = s.app.SetRoot(s.layout(), true)
+	s.savePrefs()
+	if s.showPreview {
+		s.loadPreviewForSelection()
+	}
+}
+
 // Key handlers
 
+// schedulePreview reloads the preview shortly, once the key that was just
+// pressed has moved the selection.
+func (s *AppState) schedulePreview() {
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.app.QueueUpdateDraw(s.loadPreviewForSelection)
+	}()
+}
+
+// navigate handles the list movement keys the list itself gets wrong or
+// lacks, reporting whether event was one. The "Go up" row sits at the end,
+// so the bottom is the last real entry; paging down stops there too unless
+// the cursor is already on "Go up".
+func (s *AppState) navigate(event *tcell.EventKey) bool {
+	pendingG := s.pendingG
+	s.pendingG = false
+	last := len(s.itemNames) - 1
+	if last > 0 && s.itemNames[last] == goUpName {
+		last--
+	}
+	_, _, _, page := s.filesList.GetInnerRect()
+	page = max(page, 1)
+	cur := s.filesList.GetCurrentItem()
+	switch {
+	case event.Key() == tcell.KeyPgDn, event.Key() == tcell.KeyCtrlD:
+		s.filesList.SetCurrentItem(max(min(cur+page, max(last, cur)), 0))
+	case event.Key() == tcell.KeyPgUp, event.Key() == tcell.KeyCtrlU:
+		s.filesList.SetCurrentItem(max(cur-page, 0))
+	case event.Key() == tcell.KeyHome:
+		s.filesList.SetCurrentItem(0)
+	case event.Key() == tcell.KeyEnd, event.Key() == tcell.KeyRune && event.Rune() == 'G':
+		s.filesList.SetCurrentItem(max(last, 0))
+	case event.Key() == tcell.KeyRune && event.Rune() == 'g':
+		if pendingG {
+			s.filesList.SetCurrentItem(0)
+		} else {
+			s.pendingG = true
+		}
+	default:
+		return false
+	}
+	return true
+}
+
 func (s *AppState) setupKeys() {
 	s.filesList.SetSelectedFunc(func(idx int, mainText string, secondaryText string, shortcut rune) {
 		// open on enter
@@ -584,66 +6938,76 @@ func (s *AppState) setupKeys() {
 		if idx < 0 || idx >= s.filesList.GetItemCount() {
 			return
 		}
-		label, _ := s.filesList.GetItemText(idx)
-		name := strings.TrimPrefix(label, "[::b][DIR] ")
-		if name == "[..] Go up" {
+		if s.nameAt(idx) == goUpName {
 			s.changeDir(filepath.Dir(s.currentDir))
 			return
 		}
-		path := filepath.Join(s.currentDir, name)
-		info, err := os.Stat(path)
-		if err == nil && info.IsDir() {
-			s.changeDir(path)
-		} else {
-			s.openPreview(path)
-		}
+		s.onEnter(filepath.Join(s.currentDir, s.nameAt(idx)))
 	})
 
+	s.preview.SetInputCapture(s.previewKeys)
+	// losing focus shows the overlay hints until something with hints of
+	// its own takes it
+	s.filesList.SetFocusFunc(func() { s.renderHints(s.filesList) })
+	s.filesList.SetBlurFunc(func() { s.renderHints(nil) })
+	s.preview.SetFocusFunc(func() { s.renderHints(s.preview) })
+	s.preview.SetBlurFunc(func() { s.renderHints(nil) })
+	s.keyActions = make(map[rune]action)
+	for _, a := range s.actions() {
+		s.keyActions[a.key] = a
+	}
 	s.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		switch event.Rune() {
-		case KeyQuit:
-			s.app.Stop()
-		case KeyOpen:
-			// open selected
-			idx := s.filesList.GetCurrentItem()
-			if idx < 0 {
-				break
+		// commands only apply to the file list; prompts and overlays need
+		// their keys untouched
+		if s.app.GetFocus() != s.filesList {
+			// Ctrl-C must not quit from under an open prompt or overlay
+			if event.Key() == tcell.KeyCtrlC {
+				return nil
+			}
+			return event
+		}
+		if event.Key() == tcell.KeyCtrlP {
+			s.openPalette()
+			return nil
+		}
+		if s.pendingJmp {
+			// any other key gives up on the jump and does what it does
+			s.pendingJmp = false
+			if r := event.Rune(); event.Key() == tcell.KeyRune && r >= '1' && r <= '9' {
+				s.jumpToSlot(int(r - '0'))
+				s.schedulePreview()
+				return nil
+			}
+		}
+		if s.navigate(event) {
+			s.schedulePreview()
+			return nil
+		}
+		if a, ok := s.keyActions[event.Rune()]; ok && event.Key() == tcell.KeyRune {
+			a.run()
+			if a.key == KeyMark {
+				// don't let the list treat the space as a keystroke
+				return nil
 			}
-			label, _ := s.filesList.GetItemText(idx)
-			name := strings.TrimPrefix(label, "[::b][DIR] ")
-			path := filepath.Join(s.currentDir, name)
-			_ = systemOpen(path)
-		case KeyDelete:
-			s.deleteSelection()
-		case KeyRename:
-			s.renameSelection()
-		case KeyCopy:
-			s.copySelection()
-		case KeyMove:
-			s.moveSelection()
-		case KeyBookmark:
-			s.toggleBookmark()
-		case KeyListBook:
-			s.listBookmarks()
-		case KeySearch:
-			s.promptSearch()
-		case KeyHelp:
-			s.showHelp()
 		}
 		// navigation keys
 		switch event.Key() {
 		case tcell.KeyBackspace, tcell.KeyBackspace2:
 			s.changeDir(filepath.Dir(s.currentDir))
 		case tcell.KeyEsc:
-			s.app.Stop()
+			// Esc backs out of a filtered view first
+			if !s.clearFilters() {
+				if EscQuits {
+					s.app.Stop()
+				} else {
+					s.updateStatus("Press q to quit")
+				}
+			}
 		case tcell.KeyUp, tcell.KeyDown:
 			// let the list handle
 		}
 		// on any key, update preview after a short delay for selection changes
-		go func() {
-			time.Sleep(50 * time.Millisecond)
-			s.loadPreviewForSelection()
-		}()
+		s.schedulePreview()
 		return event
 	})
 }
@@ -653,28 +7017,159 @@ func (s *AppState) setupKeys() {
 // -----------------------------
 
 func main() {
+	resetPrefsFlag := flag.Bool("reset-prefs", false, "restore the default view preferences")
+	sortFlag := flag.String("sort", "", "start sorted by `mode`: "+strings.Join(sortModeNames[:], ", "))
+	reverseFlag := flag.Bool("reverse", false, "start with the sort order reversed")
+	filterFlag := flag.String("filter", "", "start with the list filtered by `pattern` (substring, glob, or re:regexp)")
+	listFlag := flag.String("list", "", "print the listing of `dir` and exit instead of starting the browser")
+	jsonFlag := flag.Bool("json", false, "with --list, print the entries as JSON")
+	readOnlyFlag := flag.Bool("read-only", false, "turn off everything that changes files: delete, rename, copy, move, trash and editing")
+	dryRunFlag := flag.Bool("dry-run", false, "show what deletes, moves, copies and bulk renames would do without doing them")
+	flag.Parse()
+	if *jsonFlag && *listFlag == "" {
+		fmt.Fprintln(os.Stderr, "--json needs --list")
+		os.Exit(2)
+	}
+	startSort, ok := lookupSortMode(*sortFlag)
+	if *sortFlag != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Invalid --sort %q: must be one of %s\n", *sortFlag, strings.Join(sortModeNames[:], ", "))
+		os.Exit(2)
+	}
+	startFilter, err := parseFilter(*filterFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --filter %q: %v\n", *filterFlag, err)
+		os.Exit(2)
+	}
+	if *resetPrefsFlag {
+		if err := resetPrefs(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error resetting preferences:", err)
+			return
+		}
+	}
+
+	cfg, err := loadUserConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading config:", err)
+		return
+	}
+	loadTextExts(cfg)
+	loadIcons(cfg)
+	if cfg.EscQuits {
+		EscQuits = true
+	}
+	if cfg.FollowSymlinks != nil {
+		FollowSymlinks = *cfg.FollowSymlinks
+	}
+	if cfg.DirHook != "" {
+		DirHook = cfg.DirHook
+	}
+	if cfg.SortLocale != "" {
+		if _, err := language.Parse(cfg.SortLocale); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid sort_locale %q in config: %v\n", cfg.SortLocale, err)
+			return
+		}
+		SortLocale = cfg.SortLocale
+	}
+	if cfg.EntryStyle != "" {
+		if !slices.Contains(entryStyles, cfg.EntryStyle) {
+			fmt.Fprintf(os.Stderr, "Invalid entry_style %q in config: must be one of %s\n", cfg.EntryStyle, strings.Join(entryStyles, ", "))
+			return
+		}
+		EntryStyle = cfg.EntryStyle
+	}
+	if cfg.CopyDest != "" {
+		if dir := expandHome(cfg.CopyDest); cfg.CopyDest != "suffix" && cfg.CopyDest != "last" && !filepath.IsAbs(dir) {
+			fmt.Fprintf(os.Stderr, "Invalid copy_destination %q in config: must be suffix, last or an absolute directory\n", cfg.CopyDest)
+			return
+		}
+		CopyDest = cfg.CopyDest
+	}
+	for ext, limit := range cfg.PreviewMaxBytes {
+		if limit <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid preview_max_bytes for %q in config: must be positive, got %d\n", ext, limit)
+			return
+		}
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		PreviewMaxBytesByExt[ext] = limit
+	}
+	startMsg := "Ready"
+	if cfg.TimeFormat != "" {
+		if validTimeLayout(cfg.TimeFormat) {
+			TimeLayout = cfg.TimeFormat
+		} else {
+			startMsg = fmt.Sprintf("Ignoring time_format %q in config.json: it has no date or time fields", cfg.TimeFormat)
+			fmt.Fprintln(os.Stderr, startMsg)
+		}
+	}
+
 	state, err := NewAppState()
 	if err != nil {
-		fmt.Println("Error creating app:", err)
+		fmt.Fprintln(os.Stderr, "Error creating app:", err)
+		return
+	}
+	// flags override the saved preferences for this run only
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "sort":
+			state.sortMode = startSort
+		case "reverse":
+			state.sortReverse = *reverseFlag
+		case "filter":
+			state.filter = startFilter
+		}
+	})
+	state.dryRun = *dryRunFlag
+	state.readOnly = *readOnlyFlag
+
+	if *listFlag != "" {
+		if state.currentDir, err = filepath.Abs(*listFlag); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading directory:", err)
+			os.Exit(1)
+		}
+		if err := state.loadFiles(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading directory:", err)
+			os.Exit(1)
+		}
+		format := "plain"
+		if *jsonFlag {
+			format = "json"
+		}
+		if err := state.printListing(os.Stdout, format); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing listing:", err)
+			os.Exit(1)
+		}
 		return
 	}
 
 	if err := state.loadFiles(); err != nil {
-		fmt.Println("Error reading directory:", err)
+		fmt.Fprintln(os.Stderr, "Error reading directory:", err)
 		return
 	}
 
 	state.refreshList()
-	state.updateStatus("Ready")
+	state.updateStatus(startMsg)
 	state.setupKeys()
+	go func() {
+		if state.readOnly {
+			// expired trash is left for a session that may change things
+			return
+		}
+		if n, err := purgeTrash(context.Background()); err != nil {
+			state.updateStatus("Trash cleanup failed: " + err.Error())
+		} else if n > 0 {
+			state.updateStatus(fmt.Sprintf("Removed %d expired entries from the trash", n))
+		}
+	}()
 
 	root := state.layout()
 	state.app.SetRoot(root, true).EnableMouse(true)
 
-	// handle resize by redrawing preview
-	state.preview.SetChangedFunc(func() { state.app.Draw() })
+	state.app.SetAfterDrawFunc(state.onDrawn)
 
 	if err := state.app.Run(); err != nil {
-		fmt.Println("Error running app:", err)
+		fmt.Fprintln(os.Stderr, "Error running app:", err)
 	}
 }
@@ -0,0 +1,848 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// writeTree creates the files in files, by slash separated path under
+// root, with their contents.
+func writeTree(t testing.TB, root string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCopyIntoItself(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a")
+	writeTree(t, a, map[string]string{"file": "x"})
+	tests := []struct {
+		dst     string
+		refused bool
+	}{
+		{a, true},
+		{filepath.Join(a, "sub"), true},
+		{filepath.Join(a, "sub", "deeper"), true},
+		{filepath.Join(a, "..", "a", "sub"), true},
+		{a + string(filepath.Separator), true},
+		{filepath.Join(root, "a-copy"), false},
+		{filepath.Join(root, "ab"), false},
+		{root, false},
+	}
+	for _, tt := range tests {
+		if err := checkCopyTarget(a, tt.dst); (err != nil) != tt.refused {
+			t.Errorf("checkCopyTarget(%s, %s) = %v, want refused %v", a, tt.dst, err, tt.refused)
+		}
+	}
+
+	// the copy itself is refused before anything is created
+	sub := filepath.Join(a, "sub")
+	if err := osCopyFS.copyDir(a, sub); err == nil {
+		t.Fatal("copyDir into its own subdirectory succeeded")
+	}
+	if _, err := os.Lstat(sub); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("%s was created: %v", sub, err)
+	}
+}
+
+func TestRelativeTime(t *testing.T) {
+	const day = 24 * time.Hour
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{9 * time.Second, "just now"},
+		{10 * time.Second, "10 seconds ago"},
+		{59 * time.Second, "59 seconds ago"},
+		{60 * time.Second, "1 minute ago"},
+		{119 * time.Second, "1 minute ago"},
+		{59 * time.Minute, "59 minutes ago"},
+		{60 * time.Minute, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{24 * time.Hour, "1 day ago"},
+		// there is no week unit: days carry on until a month is reached
+		{6 * day, "6 days ago"},
+		{7 * day, "7 days ago"},
+		{29 * day, "29 days ago"},
+		{30 * day, "1 month ago"},
+		{59 * day, "1 month ago"},
+		{60 * day, "2 months ago"},
+		{364 * day, "12 months ago"},
+		{365 * day, "1 year ago"},
+		{3 * 365 * day, "3 years ago"},
+		// the future
+		{-5 * time.Second, "just now"},
+		{-59 * time.Second, "in 59 seconds"},
+		{-time.Minute, "in 1 minute"},
+		{-23 * time.Hour, "in 23 hours"},
+		{-24 * time.Hour, "in 1 day"},
+		{-30 * day, "in 1 month"},
+		{-2 * 365 * day, "in 2 years"},
+	}
+	for _, tt := range tests {
+		if got := relativeTime(tt.d); got != tt.want {
+			t.Errorf("relativeTime(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	oldWorkers, oldBuffer := CopyWorkers, CopyBufferSize
+	b.Cleanup(func() { CopyWorkers, CopyBufferSize = oldWorkers, oldBuffer })
+
+	large := filepath.Join(b.TempDir(), "large")
+	data := make([]byte, 64<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(large, data, 0o644); err != nil {
+		b.Fatal(err)
+	}
+	small := b.TempDir()
+	files := make(map[string]string)
+	for i := range 2000 {
+		files[filepath.Join(strconv.Itoa(i%20), strconv.Itoa(i))] = string(data[:4<<10])
+	}
+	writeTree(b, small, files)
+
+	run := func(name, src string, size int64, workers, buffer int) {
+		b.Run(name, func(b *testing.B) {
+			CopyWorkers, CopyBufferSize = workers, buffer
+			b.SetBytes(size)
+			for i := 0; b.Loop(); i++ {
+				if err := copyPath(src, filepath.Join(b.TempDir(), strconv.Itoa(i))); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+	run("large/default-buffer", large, int64(len(data)), 1, 0)
+	run("large/1MiB-buffer", large, int64(len(data)), 1, 1<<20)
+	run("small/1-worker", small, 2000*4<<10, 1, 0)
+	run("small/4-workers", small, 2000*4<<10, 4, 0)
+}
+
+// entryNames lists the names of entries in order.
+func entryNames(entries []fs.DirEntry) []string {
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.Name()
+	}
+	return names
+}
+
+func TestSizeSortTiesAreDeterministic(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"b": "same", "a": "same", "C": "same", "c": "same", "big": "larger"})
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		reverse bool
+		want    []string
+	}{
+		// ties keep name order whichever way the sort runs
+		{false, []string{"a", "b", "C", "c", "big"}},
+		{true, []string{"big", "a", "b", "C", "c"}},
+	}
+	for _, tt := range tests {
+		opts := sortOptions{mode: sortBySize, tieBreak: sortByName, reverse: tt.reverse}
+		for i := 0; i < 20; i++ {
+			s := &AppState{infoCache: make(map[string]map[string]fs.FileInfo)}
+			in := slices.Clone(entries)
+			rand.Shuffle(len(in), func(i, j int) { in[i], in[j] = in[j], in[i] })
+			if got := entryNames(s.sortEntries(dir, in, opts)); !slices.Equal(got, tt.want) {
+				t.Fatalf("reverse %v: sorted %v from %v, want %v", tt.reverse, got, entryNames(in), tt.want)
+			}
+		}
+	}
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+// failingReader returns some data and then err.
+type failingReader struct {
+	err  error
+	done bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, r.err
+	}
+	r.done = true
+	return copy(p, "partial"), nil
+}
+
+func (r *failingReader) Close() error { return nil }
+
+func TestCopyDirFailureRemovesNewDestination(t *testing.T) {
+	tests := []struct {
+		name   string
+		inject func(c *copyFS, cancel context.CancelFunc)
+		want   error
+	}{
+		{"disk full", func(c *copyFS, _ context.CancelFunc) {
+			c.create = func(name string) (io.WriteCloser, error) {
+				if filepath.Base(name) == "b" {
+					return nil, errDiskFull
+				}
+				return os.Create(name)
+			}
+		}, errDiskFull},
+		{"read error", func(c *copyFS, _ context.CancelFunc) {
+			c.open = func(name string) (io.ReadCloser, error) {
+				if filepath.Base(name) == "c" {
+					return &failingReader{err: fs.ErrInvalid}, nil
+				}
+				return os.Open(name)
+			}
+		}, fs.ErrInvalid},
+		{"cancelled", func(c *copyFS, cancel context.CancelFunc) {
+			c.open = func(name string) (io.ReadCloser, error) {
+				cancel()
+				return os.Open(name)
+			}
+		}, context.Canceled},
+		{"unreadable tree", func(c *copyFS, _ context.CancelFunc) {
+			c.readDir = func(name string) ([]fs.DirEntry, error) {
+				if filepath.Base(name) == "sub" {
+					return nil, fs.ErrPermission
+				}
+				return os.ReadDir(name)
+			}
+		}, fs.ErrPermission},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), filepath.Join(t.TempDir(), "copy")
+			writeTree(t, src, map[string]string{"a": "a", "b": "b", "sub/c": "c"})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			c := osCopyFS
+			c.ctx = ctx
+			tt.inject(&c, cancel)
+			err := c.copyPath(src, dst)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("copyPath error = %v, want %v", err, tt.want)
+			}
+			if _, err := os.Lstat(dst); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("partial copy left at %s (Lstat: %v)", dst, err)
+			}
+		})
+	}
+}
+
+func TestCopyDirFailureKeepsExistingDestination(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"a": "a", "b": "b"})
+	writeTree(t, dst, map[string]string{"mine": "keep"})
+	c := osCopyFS
+	c.create = func(name string) (io.WriteCloser, error) {
+		if filepath.Base(name) == "b" {
+			return nil, errDiskFull
+		}
+		return os.Create(name)
+	}
+	if err := c.copyDir(src, dst); !errors.Is(err, errDiskFull) {
+		t.Fatalf("copyDir error = %v, want %v", err, errDiskFull)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "mine")); err != nil || string(data) != "keep" {
+		t.Errorf("existing destination damaged: %q, %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "a")); err != nil {
+		t.Errorf("files copied before the failure should stay in an existing destination: %v", err)
+	}
+}
+
+func TestCopyPreservesModeAndTime(t *testing.T) {
+	src, dst := t.TempDir(), filepath.Join(t.TempDir(), "copy")
+	writeTree(t, src, map[string]string{"sub/run.sh": "#!/bin/sh\n"})
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, p := range []string{filepath.Join(src, "sub", "run.sh"), filepath.Join(src, "sub"), src} {
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "sub", "run.sh"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	c := osCopyFS
+	c.preserve = true
+	if err := c.copyPath(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, rel := range []string{"sub/run.sh", "sub", "."} {
+		info, err := os.Stat(filepath.Join(dst, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(old) {
+			t.Errorf("%s modified %v, want %v", rel, info.ModTime(), old)
+		}
+	}
+	if info, _ := os.Stat(filepath.Join(dst, "sub", "run.sh")); info.Mode().Perm() != 0o750 {
+		t.Errorf("mode %v, want %v", info.Mode().Perm(), fs.FileMode(0o750))
+	}
+}
+
+func TestMovePathReturnsRenameErrors(t *testing.T) {
+	dir := t.TempDir()
+	// the destination's parent is missing: a plain failure, not one to
+	// retry as a copy
+	src := filepath.Join(dir, "a")
+	writeTree(t, dir, map[string]string{"a": "a"})
+	err := movePath(src, filepath.Join(dir, "missing", "a"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("movePath error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("source should be untouched: %v", err)
+	}
+}
+
+func TestMoveOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"new/file": "new", "old/file": "old", "old/more": "old"})
+	src, dst := filepath.Join(dir, "new"), filepath.Join(dir, "old")
+
+	// a failed move puts back what it was going to replace
+	var m moveSummary
+	m.move(filepath.Join(dir, "missing"), dst, conflictOverwrite)
+	if len(m.failures) != 1 || !errors.Is(m.failures[0].err, fs.ErrNotExist) {
+		t.Fatalf("failures = %v, want the missing source", m.failures)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "more")); err != nil || string(data) != "old" {
+		t.Fatalf("replaced entry lost after a failed move: %q, %v", data, err)
+	}
+
+	m = moveSummary{}
+	m.move(src, dst, conflictOverwrite)
+	if len(m.failures) > 0 || m.overwritten != 1 {
+		t.Fatalf("overwrite: failures %v, overwritten %d", m.failures, m.overwritten)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "file")); err != nil || string(data) != "new" {
+		t.Errorf("destination holds %q, %v; want the moved entry", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "more")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("replaced directory merged instead of replaced: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("left behind in %s: %v", dir, entries)
+	}
+}
+
+func TestLocaleSort(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"zebra", "éclair", "Eve", "eclair", "apple", "Ärger", "中文.txt", "日本.txt", "한국.txt"} {
+		writeTree(t, dir, map[string]string{name: ""})
+	}
+	writeTree(t, dir, map[string]string{"ödir/x": ""})
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		locale string
+		opts   sortOptions
+		want   []string
+	}{
+		// without a locale names sort by bytes, accents after z
+		{"", sortOptions{}, []string{"apple", "eclair", "Eve", "zebra", "Ärger", "éclair", "ödir", "中文.txt", "日本.txt", "한국.txt"}},
+		{"de", sortOptions{}, []string{"apple", "Ärger", "eclair", "éclair", "Eve", "ödir", "zebra", "한국.txt", "中文.txt", "日本.txt"}},
+		{"de", sortOptions{dirsFirst: true, reverse: true}, []string{"ödir", "日本.txt", "中文.txt", "한국.txt", "zebra", "Eve", "éclair", "eclair", "Ärger", "apple"}},
+	}
+	old := SortLocale
+	defer func() { SortLocale = old }()
+	for _, tt := range tests {
+		SortLocale = tt.locale
+		for range 5 {
+			shuffled := slices.Clone(entries)
+			rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+			s := &AppState{infoCache: make(map[string]map[string]fs.FileInfo)}
+			if got := entryNames(s.sortEntries(dir, shuffled, tt.opts)); !slices.Equal(got, tt.want) {
+				t.Errorf("locale %q %+v: got %v, want %v", tt.locale, tt.opts, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestFilterUnicode(t *testing.T) {
+	tests := []struct {
+		term, name string
+		want       bool
+	}{
+		{"é", "Éclair.txt", true},
+		{"ÉCLAIR", "éclair.txt", true},
+		{"é", "eclair.txt", false},
+		{"straße", "STRASSE.txt", false},
+		{"文", "中文.txt", true},
+		{"日本", "中文.txt", false},
+		{"*文*", "中文.txt", true},
+		{"?文.txt", "中文.txt", true},
+		{"re:^한", "한국.txt", true},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.term)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.term, err)
+			continue
+		}
+		if got := f.matches(tt.name); got != tt.want {
+			t.Errorf("filter %q (%s) matches %q = %v, want %v", tt.term, f.mode(), tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterBrackets(t *testing.T) {
+	tests := []struct {
+		term, name string
+		want       bool
+	}{
+		{"[draft]", "[draft] notes.txt", true},
+		{"[DRAFT]", "[draft] notes.txt", true},
+		{"[draft]", "d", false},
+		{"[draft", "old [draft notes", true},
+		{"draft]", "[draft] notes.txt", true},
+		// in a glob brackets are a character class, and escaped are literal
+		{"[draft]*", "d.txt", true},
+		{"[draft]*", "[draft] notes.txt", false},
+		{`\[draft\]*`, "[draft] notes.txt", true},
+		{"re:^\\[draft\\]", "[draft] notes.txt", true},
+	}
+	for _, tt := range tests {
+		f, err := parseFilter(tt.term)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tt.term, err)
+			continue
+		}
+		if got := f.matches(tt.name); got != tt.want {
+			t.Errorf("filter %q (%s) matches %q = %v, want %v", tt.term, f.mode(), tt.name, got, tt.want)
+		}
+	}
+}
+
+// startApp runs the browser in dir on a simulated screen until the test
+// ends.
+func startApp(t *testing.T, dir string) *AppState {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	loadTextExts(userConfig{})
+	s, err := NewAppState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.currentDir = dir
+	s.app.SetScreen(tcell.NewSimulationScreen(""))
+	s.app.SetRoot(s.layout(), true)
+	s.setupKeys()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = s.app.Run()
+	}()
+	t.Cleanup(func() {
+		s.app.Stop()
+		<-done
+	})
+	s.refreshList()
+	return s
+}
+
+// waitFor polls cond on the UI goroutine until it holds.
+func waitFor(t *testing.T, s *AppState, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		var ok bool
+		s.app.QueueUpdate(func() { ok = cond() })
+		if ok {
+			return
+		}
+	}
+	t.Fatal("timed out waiting for " + what)
+}
+
+// shown is text as tview displays it, with color tags parsed away.
+func shown(text string) string {
+	return tview.NewTextView().SetDynamicColors(true).SetText(text).GetText(true)
+}
+
+func TestBracketNamesShowLiterally(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{
+		"[draft] notes.txt": "[red]x\n",
+		"[red]x":            "\x00\x01\x02",
+		"[draft]/f":         "",
+	})
+	s := startApp(t, dir)
+	waitFor(t, s, "the listing", func() bool { return len(s.itemNames) == 4 }) // and the "Go up" row
+
+	tests := []struct {
+		name, label, preview string
+	}{
+		{"[draft] notes.txt", "[draft] notes.txt", "[draft] notes.txt  7 B, 1 line, ASCII\n\n[red]x"},
+		{"[red]x", "[red]x", "[red]x\nSize: "},
+		{"[draft]", "[DIR] [draft]", "[DIR] [draft]\n"},
+	}
+	for _, tt := range tests {
+		var label string
+		s.app.QueueUpdate(func() {
+			s.selectName(tt.name)
+			label, _ = s.filesList.GetItemText(s.filesList.GetCurrentItem())
+			s.loadPreviewForSelection()
+		})
+		if got := shown(label); got != tt.label {
+			t.Errorf("list label for %q shows %q, want %q", tt.name, got, tt.label)
+		}
+		var preview string
+		waitFor(t, s, "the preview of "+tt.name, func() bool {
+			preview = s.preview.GetText(true)
+			return preview != "Loading..."
+		})
+		if !strings.HasPrefix(preview, tt.preview) {
+			t.Errorf("preview of %q starts %q, want %q", tt.name, preview, tt.preview)
+		}
+	}
+}
+
+func TestNamesThatLookLikeLabels(t *testing.T) {
+	// names that start the way a directory's list label does
+	const file, dir = "[::b][DIR] notes", "[::b][DIR] sub"
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{file: "x", dir + "/f": "", "other": ""})
+	oldConfirm, oldTrash := ConfirmDelete, UseTrash
+	ConfirmDelete, UseTrash = "never", false
+	defer func() { ConfirmDelete, UseTrash = oldConfirm, oldTrash }()
+	s := startApp(t, root)
+	waitFor(t, s, "the listing", func() bool { return len(s.itemNames) == 4 })
+
+	for _, name := range []string{file, dir} {
+		var got string
+		s.app.QueueUpdate(func() {
+			s.selectName(name)
+			got = s.selectedPath()
+			s.toggleMark()
+		})
+		if want := filepath.Join(root, name); got != want {
+			t.Errorf("selected path %q, want %q", got, want)
+		}
+	}
+	var marked []string
+	s.app.QueueUpdate(func() { marked = s.markedPaths() })
+	if want := []string{filepath.Join(root, file), filepath.Join(root, dir)}; !slices.Equal(marked, want) {
+		t.Errorf("marked %q, want %q", marked, want)
+	}
+	s.app.QueueUpdate(func() { s.selected = make(map[string]bool) })
+
+	var prefill string
+	s.app.QueueUpdate(func() {
+		s.selectName(file)
+		s.renameSelection()
+		prefill = s.app.GetFocus().(*tview.InputField).GetText()
+		s.app.SetRoot(s.layout(), true)
+	})
+	if prefill != file {
+		t.Errorf("rename offered %q, want %q", prefill, file)
+	}
+
+	s.app.QueueUpdate(func() { s.selectName(dir) })
+	// called from here, as its status update waits for the UI goroutine
+	s.deleteSelection()
+	waitFor(t, s, "the delete", func() bool { return !slices.Contains(s.itemNames, dir) })
+	names, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := entryNames(names), []string{file, "other"}; !slices.Equal(got, want) {
+		t.Errorf("left %q, want %q", got, want)
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a":           "12345",
+		"sub/b":       "1234567890",
+		"sub/deep/c":  "123",
+		"sub/empty/d": "",
+	})
+	if err := os.Symlink("a", filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	progress := make(chan int64)
+	size, err := dirSize(context.Background(), root, progress)
+	if err != nil || size != 18 {
+		t.Errorf("dirSize = %d, %v; want 18", size, err)
+	}
+	if _, open := <-progress; open {
+		t.Error("progress channel left open")
+	}
+	if size, err := dirSize(context.Background(), filepath.Join(root, "sub", "b"), nil); err != nil || size != 10 {
+		t.Errorf("dirSize of a file = %d, %v; want 10", size, err)
+	}
+}
+
+// cancelAfter is a context that reports itself cancelled once Err has been
+// asked limit times, so a test can cancel a walk at a known point.
+type cancelAfter struct {
+	context.Context
+	limit, calls int
+}
+
+func (c *cancelAfter) Err() error {
+	c.calls++
+	if c.calls > c.limit {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDirSizeCancelStopsWalk(t *testing.T) {
+	root := t.TempDir()
+	files := make(map[string]string)
+	for i := range 100 {
+		files[filepath.Join(strconv.Itoa(i%10), strconv.Itoa(i))] = "x"
+	}
+	writeTree(t, root, files)
+	before := runtime.NumGoroutine()
+
+	ctx := &cancelAfter{Context: context.Background(), limit: 20}
+	progress := make(chan int64)
+	size, err := dirSize(ctx, root, progress)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("dirSize after cancel = %d, %v; want context.Canceled", size, err)
+	}
+	if ctx.calls != ctx.limit+1 {
+		t.Errorf("walk went on for %d more entries after the cancel", ctx.calls-ctx.limit-1)
+	}
+	if _, open := <-progress; open {
+		t.Error("progress channel left open")
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines before, %d after", before, after)
+	}
+
+	// a cancelled walk's partial total must not be remembered
+	if size, err := dirSize(context.Background(), root, nil); err != nil || size != 100 {
+		t.Errorf("dirSize after a cancelled walk = %d, %v; want 100", size, err)
+	}
+}
+
+// touchedInfo is a FileInfo with another modtime, as if the directory had
+// changed since it was measured.
+type touchedInfo struct {
+	fs.FileInfo
+	modTime time.Time
+}
+
+func (i touchedInfo) ModTime() time.Time { return i.modTime }
+
+func TestLastCopyDirSetOnlyOnceQueued(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"a": "x"})
+	s := startApp(t, src)
+	paths := []string{filepath.Join(src, "a")}
+
+	s.app.QueueUpdate(func() {
+		s.dryRun = true
+		s.copyInto(paths, dst)
+	})
+	s.app.QueueUpdate(func() {
+		if s.lastCopyDir != "" {
+			t.Errorf("dry run remembered %q as the last copy destination", s.lastCopyDir)
+		}
+		s.dryRun = false
+		s.copyInto(paths, dst)
+		if s.lastCopyDir != dst {
+			t.Errorf("last copy destination = %q, want %q", s.lastCopyDir, dst)
+		}
+	})
+	waitFor(t, s, "the copy", func() bool {
+		_, err := os.Stat(filepath.Join(dst, "a"))
+		return err == nil
+	})
+}
+
+func TestDirSizeCacheBounded(t *testing.T) {
+	info, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeDirSize("/stale", info, 1)
+	touched := touchedInfo{info, info.ModTime().Add(time.Second)}
+	if _, ok := cachedDirSize("/stale", touched); ok {
+		t.Error("size of a modified directory still served")
+	}
+	dirSizeCacheLock.Lock()
+	_, kept := dirSizeCache["/stale"]
+	dirSizeCacheLock.Unlock()
+	if kept {
+		t.Error("stale entry kept after lookup")
+	}
+
+	storeDirSize("/first", info, 1)
+	for i := range dirSizeCacheMax - 1 {
+		storeDirSize("/dir"+strconv.Itoa(i), info, int64(i))
+	}
+	// using /first makes /dir0 the least recently used
+	if size, ok := cachedDirSize("/first", info); !ok || size != 1 {
+		t.Errorf("cachedDirSize(/first) = %d, %v; want 1, true", size, ok)
+	}
+	storeDirSize("/last", info, 2)
+	if _, ok := cachedDirSize("/dir0", info); ok {
+		t.Error("least recently used entry not dropped")
+	}
+	for _, p := range []string{"/first", "/dir1", "/last"} {
+		if _, ok := cachedDirSize(p, info); !ok {
+			t.Errorf("%s dropped", p)
+		}
+	}
+	dirSizeCacheLock.Lock()
+	n := len(dirSizeCache)
+	dirSizeCacheLock.Unlock()
+	if n > dirSizeCacheMax {
+		t.Errorf("%d cached sizes, want at most %d", n, dirSizeCacheMax)
+	}
+}
+
+func TestPlanCopyDirDeepTree(t *testing.T) {
+	// 10k levels make paths far longer than the OS allows, so this tree
+	// only exists in a fake filesystem: every directory holds one
+	// directory "d" down to the bottom one, which holds a file "f"
+	const depth = 10000
+	tmp := t.TempDir()
+	writeTree(t, tmp, map[string]string{"d/f": ""})
+	dirInfo, err := os.Stat(filepath.Join(tmp, "d"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(filepath.Join(tmp, "d", "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var made int
+	c := copyFS{
+		stat: func(string) (fs.FileInfo, error) { return dirInfo, nil },
+		readDir: func(name string) ([]fs.DirEntry, error) {
+			if strings.Count(name, "d") == depth {
+				return []fs.DirEntry{fs.FileInfoToDirEntry(fileInfo)}, nil
+			}
+			return []fs.DirEntry{fs.FileInfoToDirEntry(dirInfo)}, nil
+		},
+		mkdirAll: func(string, fs.FileMode) error { made++; return nil },
+	}
+	var jobs []copyJob
+	dirs, err := c.planCopyDir("/src", "/dst", &jobs)
+	if err != nil {
+		t.Fatalf("planCopyDir: %v", err)
+	}
+	if len(dirs) != depth+1 || made != depth+1 {
+		t.Errorf("planned %d directories and made %d, want %d", len(dirs), made, depth+1)
+	}
+	if len(jobs) != 1 || strings.Count(jobs[0].dst, "d") != depth+1 || filepath.Base(jobs[0].dst) != "f" {
+		t.Errorf("file jobs = %d, want the one file at the bottom", len(jobs))
+	}
+
+	// and a tree as deep as the OS allows comfortably, for real
+	src := t.TempDir()
+	bottom := filepath.Join(src, strings.Repeat("d/", 1000))
+	if err := os.MkdirAll(bottom, 0o755); err != nil {
+		t.Skip("cannot build a deep tree here:", err)
+	}
+	writeTree(t, bottom, map[string]string{"f": "deep"})
+	dst := filepath.Join(t.TempDir(), "copy")
+	if err := osCopyFS.copyDir(src, dst); err != nil {
+		t.Fatalf("copyDir: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, strings.Repeat("d/", 1000), "f")); err != nil || string(data) != "deep" {
+		t.Errorf("bottom file = %q, %v", data, err)
+	}
+}
+
+// countedEntry counts the stats its Info does.
+type countedEntry struct {
+	fs.DirEntry
+	stats *atomic.Int64
+}
+
+func (e countedEntry) Info() (fs.FileInfo, error) {
+	e.stats.Add(1)
+	return e.DirEntry.Info()
+}
+
+func BenchmarkSortEntries(b *testing.B) {
+	dir := b.TempDir()
+	files := make(map[string]string)
+	for i := range 5000 {
+		name := "f" + strconv.Itoa(i)
+		if i%10 == 0 {
+			name = "d" + strconv.Itoa(i) + "/x"
+		}
+		files[name] = strings.Repeat("x", i%100)
+	}
+	writeTree(b, dir, files)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var stats atomic.Int64
+	counted := make([]fs.DirEntry, len(entries))
+	for i, e := range entries {
+		counted[i] = countedEntry{e, &stats}
+	}
+	for _, mode := range []sortMode{sortByName, sortBySize} {
+		b.Run(mode.String(), func(b *testing.B) {
+			stats.Store(0)
+			opts := sortOptions{mode: mode, tieBreak: sortByName, dirsFirst: true}
+			for b.Loop() {
+				// a fresh cache each time, as after re-reading the directory
+				s := &AppState{infoCache: make(map[string]map[string]fs.FileInfo)}
+				s.sortEntries(dir, counted, opts)
+			}
+			b.ReportMetric(float64(stats.Load())/float64(b.N), "stats/op")
+		})
+	}
+}
+
+func TestDescribeProbeDuration(t *testing.T) {
+	tests := []struct {
+		duration, want string
+	}{
+		{"3.9", "Duration: 4s"},
+		{"3.4", "Duration: 3s"},
+		{"0.2", "Duration: 0s"},
+		{"3725.500000", "Duration: 1h2m6s"},
+		{"N/A", ""},
+	}
+	for _, tt := range tests {
+		got, err := describeProbe([]byte(`{"format": {"duration": "` + tt.duration + `"}}`))
+		if err != nil || got != tt.want {
+			t.Errorf("duration %q: %q, %v; want %q", tt.duration, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestSearchTreeSkipsEntriesDeletedMidWalk(t *testing.T) {
+	loadTextExts(userConfig{})
+	root := t.TempDir()
+	// a.txt is a FIFO, so the walk stops on opening it until the test has
+	// deleted entries it has already listed but not yet reached
+	fifo := filepath.Join(root, "a.txt")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skip("no FIFOs here:", err)
+	}
+	for _, name := range []string{"b.txt", "c/d.txt", "e.txt"} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("a needle\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		os.Remove(filepath.Join(root, "b.txt"))
+		os.RemoveAll(filepath.Join(root, "c"))
+		w.WriteString("needle\n")
+		w.Close()
+	}()
+
+	progress := make(chan searchProgress)
+	final := make(chan searchProgress, 1)
+	go func() {
+		for p := range progress {
+			if p.done {
+				final <- p
+				return
+			}
+		}
+	}()
+	results, err := searchTree(context.Background(), root, "needle", true, false, progress)
+	if err != nil {
+		t.Fatalf("searchTree: %v", err)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, filepath.Base(r.path))
+	}
+	if want := []string{"a.txt", "e.txt"}; !slices.Equal(got, want) {
+		t.Errorf("matches = %v, want %v", got, want)
+	}
+	if p := <-final; p.unreadable != 0 || p.err != nil {
+		t.Errorf("final report: %d unreadable, err %v; deleted entries should pass quietly", p.unreadable, p.err)
+	}
+}
+
+func TestMovePathAcrossFilesystems(t *testing.T) {
+	other, err := os.MkdirTemp("/dev/shm", "move")
+	if err != nil {
+		t.Skip("no second filesystem:", err)
+	}
+	defer os.RemoveAll(other)
+	src := t.TempDir()
+	if sameFilesystem(src, other) {
+		t.Skip("/dev/shm is on the same filesystem as", src)
+	}
+	writeTree(t, src, map[string]string{"dir/file": "data"})
+	if err := os.Symlink("file", filepath.Join(src, "dir", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "dir", "file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	dst := filepath.Join(other, "dir")
+	if err := movePath(filepath.Join(src, "dir"), dst); err != nil {
+		t.Fatalf("movePath: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(src, "dir")); !os.IsNotExist(err) {
+		t.Errorf("source still there after the move: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "link")); err != nil || target != "file" {
+		t.Errorf("link not kept as a link: %q, %v", target, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "file")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode not kept: %v, %v", info, err)
+	}
+}
+
+func TestTextPreviewStreamsBeforeCountingLines(t *testing.T) {
+	dir := t.TempDir()
+	fifo := filepath.Join(dir, "log.txt")
+	if err := syscall.Mkfifo(fifo, 0o644); err != nil {
+		t.Skip("no FIFOs here:", err)
+	}
+	s := startApp(t, dir)
+	waitFor(t, s, "the listing", func() bool { return len(s.itemNames) == 2 })
+	s.app.QueueUpdate(func() {
+		s.selectName("log.txt")
+		s.loadPreviewForSelection()
+	})
+	w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := range 300 {
+		fmt.Fprintf(w, "line %03d of the log, padded out a bit\n", i)
+	}
+	// the file is still open for writing, so it hasn't all been read, yet
+	// the first lines are already shown
+	var text string
+	waitFor(t, s, "the first lines", func() bool {
+		text = s.preview.GetText(true)
+		return strings.Contains(text, "line 099")
+	})
+	if want := "log.txt  0 B, counting lines...\n\nline 000"; !strings.HasPrefix(text, want) {
+		t.Errorf("preview starts %q, want %q", text, want)
+	}
+	w.Close()
+	waitFor(t, s, "the line count", func() bool {
+		text = s.preview.GetText(true)
+		return !strings.Contains(text, "counting lines")
+	})
+	if want := "log.txt  0 B, 300 lines, ASCII\n\nline 000"; !strings.HasPrefix(text, want) {
+		t.Errorf("preview starts %q, want %q", text, want)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("free space not supported on this platform")
+}
+
+// sameFilesystem cannot tell devices apart here, so it assumes they match
+// and leaves it to the operation itself to fail.
+func sameFilesystem(a, b string) bool {
+	return true
+}
+
+// crossDevice cannot recognise the error here, so renames are never
+// retried as a copy.
+func crossDevice(err error) bool {
+	return false
+}
+
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+func xattrNames(path string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// freeSpace returns the bytes available to the current user on the volume
+// holding path.
+func freeSpace(path string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var avail, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &avail, &total, &free); err != nil {
+		return 0, err
+	}
+	return avail, nil
+}
+
+// sameFilesystem reports whether a and b are on the same volume.
+func sameFilesystem(a, b string) bool {
+	va, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	vb, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(filepath.VolumeName(va), filepath.VolumeName(vb))
+}
+
+// crossDevice reports whether err is a rename failing because source and
+// destination are on different volumes.
+func crossDevice(err error) bool {
+	return errors.Is(err, windows.ERROR_NOT_SAME_DEVICE)
+}
+
+// linkCount is not exposed by os.Stat on Windows.
+func linkCount(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// xattrNames is not supported; Windows keeps this kind of metadata in
+// alternate data streams and security descriptors instead.
+func xattrNames(path string) ([]string, error) {
+	return nil, errors.ErrUnsupported
+}